@@ -1,14 +1,24 @@
 package main
 
 import (
+	"flag"
 	"log"
 
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
 )
 
 func main() {
 
-	app, err := app.New()
+	c := config.New()
+	if flag.NArg() > 0 {
+		if err := app.Command(c, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	app, err := app.New(c)
 	if err != nil {
 		log.Fatal(err)
 	}
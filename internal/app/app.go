@@ -2,17 +2,27 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/endpoint"
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/mware"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/pb"
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/service"
 
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type App struct {
@@ -21,37 +31,247 @@ type App struct {
 	s  *service.Service
 	e  *endpoint.Endpoint
 	r  chi.Router
+	g  *grpc.Server // nil if gRPC is not served
+	tc *tls.Config  // nil if HTTPS is off
+
+	limiters map[string]*mware.RateLimiter // by route group
+	reloadMu sync.Mutex
 }
 
-func New() (*App, error) {
+func New(c *config.Config) (*App, error) {
 	a := &App{}
-	a.c = config.New()
+	a.c = c
 	a.ds = repository.New(a.c)
 	a.s = service.New(a.ds, a.c)
-	a.e = endpoint.New(a.s, a.c)
+	userID, err := mware.NewUserID(a.c, a.s, a.s)
+	if err != nil {
+		return nil, err
+	}
+	trusted, err := mware.NewTrustedSubnet(a.c.TrustedSubnet)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := config.ParseRateLimits(a.c.RateLimits)
+	if err != nil {
+		return nil, err
+	}
+	// every route group has its own limiter
+	a.limiters = make(map[string]*mware.RateLimiter)
+	limit := func(group string) func(http.Handler) http.Handler {
+		a.limiters[group] = mware.NewRateLimiter(limits[group], trusted)
+		return a.limiters[group].Handler
+	}
+	if a.c.EnableHTTPS {
+		if a.tc, err = tlsConfig(a.c); err != nil {
+			return nil, err
+		}
+	}
+	a.e = endpoint.New(a.s, a.c, userID, a)
 	a.r = chi.NewRouter()
+	a.r.Use(mware.PeerAddr)
 	a.r.Use(middleware.RealIP)
 	a.r.Use(middleware.Logger)
 	a.r.Use(middleware.Recoverer)
 	a.r.Use(mware.GzipResponse)
 	a.r.Use(mware.GunzipRequest)
-	a.r.Use(mware.UserID)
+	a.r.Use(userID.Handler)
 
 	a.r.Get("/ping", a.e.Ping)
-	a.r.Get("/{id}", a.e.Get)
-	a.r.Get("/api/user/urls", a.e.ShowURLByUser)
 	a.r.Get("/info", a.e.Info)
-	a.r.Post("/", a.e.Post)
-	a.r.Post("/api/shorten", a.e.PostAPI)
-	a.r.Post("/api/shorten/batch", a.e.PostBatchAPI)
-	a.r.Delete("/api/user/urls", a.e.DeleteBatch)
+	a.r.With(trusted.Handler).Get("/api/internal/stats", a.e.InternalStats)
+	a.r.With(limit(config.RateRedirect)).Get("/{id}", a.e.Get)
+	a.r.Group(func(r chi.Router) {
+		r.Use(limit(config.RateCreate))
+		r.Post("/", a.e.Post)
+		r.Post("/api/shorten", a.e.PostAPI)
+		r.Post("/api/shorten/batch", a.e.PostBatchAPI)
+	})
+	a.r.Group(func(r chi.Router) {
+		r.Use(limit(config.RateAuth))
+		r.Post("/api/auth/register", a.e.Register)
+		r.Post("/api/auth/login", a.e.Login)
+		r.Post("/api/auth/logout", a.e.Logout)
+	})
+	a.r.Group(func(r chi.Router) {
+		r.Use(limit(config.RateAPI))
+		r.Get("/api/user/urls", a.e.ShowURLByUser)
+		r.Get("/api/user/urls/{id}/stats", a.e.ShowStats)
+		r.Get("/api/user/jobs/{id}", a.e.ShowDeleteJob)
+		r.Delete("/api/user/urls", a.e.DeleteBatch)
+		r.Get("/api/user/keys", a.e.ShowAPIKeys)
+		r.Post("/api/user/keys", a.e.CreateAPIKey)
+		r.Patch("/api/user/keys/{id}", a.e.RenameAPIKey)
+		r.Delete("/api/user/keys/{id}", a.e.RevokeAPIKey)
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(a.e.AdminOnly)
+			r.Get("/urls", a.e.AdminSearch)
+			r.Delete("/urls/{id}", a.e.AdminDelete)
+			r.Post("/urls/{id}/restore", a.e.AdminRestore)
+			r.Put("/urls/{id}/owner", a.e.AdminReassign)
+			r.Get("/audit", a.e.ShowAuditLog)
+			r.Post("/reload", a.e.ReloadConfig)
+		})
+	})
+
+	if a.c.GRPCListen != "" {
+		opts := []grpc.ServerOption{
+			grpc.ChainUnaryInterceptor(
+				userID.UnaryInterceptor,
+				trusted.UnaryInterceptor(pb.Shortener_Stats_FullMethodName),
+				// methods share limiters with their HTTP routes, so reload applies to both
+				mware.RateLimitInterceptor(map[string]*mware.RateLimiter{
+					pb.Shortener_Shorten_FullMethodName:        a.limiters[config.RateCreate],
+					pb.Shortener_ShortenBatch_FullMethodName:   a.limiters[config.RateCreate],
+					pb.Shortener_Resolve_FullMethodName:        a.limiters[config.RateRedirect],
+					pb.Shortener_ListUserURLs_FullMethodName:   a.limiters[config.RateAPI],
+					pb.Shortener_DeleteUserURLs_FullMethodName: a.limiters[config.RateAPI],
+				}),
+			),
+		}
+		if a.tc != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(a.tc)))
+		}
+		a.g = grpc.NewServer(opts...)
+		pb.RegisterShortenerServer(a.g, endpoint.NewGRPC(a.s))
+	}
 
 	return a, a.s.PingDB(context.Background())
 }
 
+// Serves until SIGINT or SIGTERM, then shuts down step by step.
+// SIGHUP reloads config.
 func (a *App) Run() error {
-	log.Println("service running")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	srv := &http.Server{
+		Addr:    a.c.Listen,
+		Handler: a.r,
+	}
+	servers := []*http.Server{srv}
+	served := make(chan error, 3)
+	if a.g != nil {
+		listener, err := net.Listen("tcp", a.c.GRPCListen)
+		if err != nil {
+			return err
+		}
+		go func() {
+			served <- a.g.Serve(listener)
+		}()
+		log.Printf("serving gRPC on %s", a.c.GRPCListen)
+	}
+	if a.c.EnableHTTPS {
+		srv.TLSConfig = a.tc
+		go func() {
+			served <- srv.ListenAndServeTLS("", "")
+		}()
+		if a.c.HTTPRedirect != "" {
+			redirect := &http.Server{
+				Addr:    a.c.HTTPRedirect,
+				Handler: redirectHTTPS(a.c.Listen),
+			}
+			servers = append(servers, redirect)
+			go func() {
+				served <- redirect.ListenAndServe()
+			}()
+			log.Printf("redirecting HTTP from %s to HTTPS", a.c.HTTPRedirect)
+		}
+	} else {
+		go func() {
+			served <- srv.ListenAndServe()
+		}()
+	}
+	log.Printf("service running, short urls are %s...", a.c.HostName)
+
+	var err error
+	for running := true; running; {
+		select {
+		case err = <-served:
+			log.Printf("server failed: %v", err)
+			running = false
+		case <-ctx.Done():
+			log.Println("shutdown: signal received")
+			running = false
+		case <-hup:
+			// error is logged by Reload, old config stays
+			a.Reload()
+		}
+	}
+	// second signal kills at once
+	stop()
+	return errors.Join(err, a.shutdown(servers...))
+}
+
+// Reads config again and applies its runtime part: ADDHOST, ADMIN_USERS
+// and RATE_LIMITS. Changes of other fields are logged and wait for restart.
+func (a *App) Reload() (config.Runtime, error) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	c, err := a.c.Reload()
+	if err != nil {
+		log.Printf("config reload: config is not valid, nothing is changed: %v", err)
+		return config.Runtime{}, err
+	}
+	for _, key := range a.c.NeedRestart(c) {
+		log.Printf("config reload: %s is changed, it is applied after restart", key)
+	}
+	rt := c.Runtime()
+	// valid config has valid limits
+	limits, _ := config.ParseRateLimits(rt.RateLimits)
+	for group, limiter := range a.limiters {
+		limiter.SetLimit(limits[group])
+	}
+	a.s.Reload(rt)
+	log.Printf("config reload: addhost=%v admin_users=%q rate_limits=%q blocked_hosts=%q",
+		rt.RetShrtWHost, rt.Admins, rt.RateLimits, rt.BlockedHosts)
+	return rt, nil
+}
+
+func (a *App) shutdown(servers ...*http.Server) error {
+	log.Printf("shutdown: stop accepting connections, draining requests for %v", a.c.ShutdownTime)
+	ctx, cancel := context.WithTimeout(context.Background(), a.c.ShutdownTime)
+	defer cancel()
+	var err error
+	// gRPC calls are drained along with HTTP requests
+	var grpcStopped chan struct{}
+	if a.g != nil {
+		grpcStopped = make(chan struct{})
+		go func() {
+			a.g.GracefulStop()
+			close(grpcStopped)
+		}()
+	}
+	for _, srv := range servers {
+		if errSrv := srv.Shutdown(ctx); errSrv != nil {
+			log.Printf("shutdown: requests to %s not drained, closing connections: %v", srv.Addr, errSrv)
+			err = errors.Join(err, srv.Close())
+		}
+	}
+	if a.g != nil {
+		select {
+		case <-grpcStopped:
+		case <-ctx.Done():
+			log.Printf("shutdown: gRPC calls not drained, closing connections: %v", ctx.Err())
+			a.g.Stop()
+			err = errors.Join(err, ctx.Err())
+		}
+	}
+	if err == nil {
+		log.Println("shutdown: requests drained")
+	}
+
+	log.Printf("shutdown: flushing deletion and click queues for %v", a.c.ShutdownTime)
+	ctx, cancel = context.WithTimeout(context.Background(), a.c.ShutdownTime)
+	defer cancel()
+	errFlush := a.s.Close(ctx)
 
-	err := http.ListenAndServe(a.c.Listen, a.r)
-	return err
+	log.Println("shutdown: closing storage")
+	errClose := a.ds.Close()
+	if errClose != nil {
+		log.Printf("shutdown: error closing storage: %v", errClose)
+	}
+	log.Println("shutdown: done")
+	return errors.Join(err, errFlush, errClose)
 }
@@ -3,19 +3,29 @@ package app
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/pb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var pairs []model.ShortURL
@@ -27,10 +37,21 @@ func TestApp_Run(t *testing.T) {
 	t.Run("Endpoint POST test", endpointPostTest)
 	t.Run("Endpoint POST api test", endpointPostAPITest)
 	t.Run("Endpoint GET test", endpointGetTest)
+	t.Run("Endpoint alias test", endpointAliasTest)
+	t.Run("Endpoint expiry test", endpointExpiryTest)
+	t.Run("Endpoint stats test", endpointStatsTest)
+	t.Run("Endpoint delete job test", endpointDeleteJobTest)
+	t.Run("Endpoint auth test", endpointAuthTest)
+	t.Run("Endpoint api key test", endpointAPIKeyTest)
+	t.Run("Endpoint admin test", endpointAdminTest)
+	t.Run("Endpoint internal stats test", endpointInternalStatsTest)
+	t.Run("gRPC test", grpcTest)
+	t.Run("Concurrent stress test", concurrentStressTest)
 }
 
 func initTest(t *testing.T) {
-	tsApp, _ := New()
+	t.Setenv("GRPC_ADDRESS", "localhost:3200")
+	tsApp, _ := New(config.New())
 	go tsApp.Run()
 	time.Sleep(500 * time.Millisecond)
 
@@ -214,6 +235,461 @@ func endpointGetTest(t *testing.T) {
 	}
 }
 
+func endpointAliasTest(t *testing.T) {
+	alias := "sale-" + generateRandStr(8)
+	tests := []struct {
+		body      map[string]string
+		retStatus int
+		retBody   string
+	}{
+		{body: map[string]string{"url": "http://" + generateRandStr(20) + ".ru", "alias": alias},
+			retStatus: http.StatusCreated,
+			retBody:   "/" + alias},
+		{body: map[string]string{"url": "http://" + generateRandStr(20) + ".ru", "alias": alias},
+			retStatus: http.StatusConflict,
+			retBody:   config.ErrAliasTaken.Error()},
+		{body: map[string]string{"url": "http://" + generateRandStr(20) + ".ru", "alias": "Ping"},
+			retStatus: http.StatusBadRequest,
+			retBody:   config.ErrAliasReserved.Error()},
+		{body: map[string]string{"url": "http://" + generateRandStr(20) + ".ru", "alias": "spring sale"},
+			retStatus: http.StatusBadRequest,
+			retBody:   config.ErrAliasNotValid.Error()},
+		{body: map[string]string{"url": pairs[0].URL, "alias": "other-" + generateRandStr(8)},
+			retStatus: http.StatusConflict,
+			retBody:   config.ErrURLHasShort.Error()},
+	}
+	for _, tt := range tests {
+		reqBody, _ := json.Marshal(tt.body)
+		resp, err := http.Post("http://localhost:8080/api/shorten", "application/json", bytes.NewReader(reqBody))
+		require.Nil(t, err)
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, tt.retStatus, resp.StatusCode)
+		assert.Contains(t, string(respBody), tt.retBody)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://localhost:8080/" + alias)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	assert.Equal(t, tests[0].body["url"], resp.Header.Get("Location"))
+}
+
+func endpointExpiryTest(t *testing.T) {
+	tests := []struct {
+		body      string
+		retStatus int
+	}{
+		{body: `{"url": "http://%s.ru", "ttl": 1}`, retStatus: http.StatusCreated},
+		{body: `{"url": "http://%s.ru", "ttl": "1s"}`, retStatus: http.StatusCreated},
+		{body: `{"url": "http://%s.ru", "ttl": "1"}`, retStatus: http.StatusCreated},
+		{body: `{"url": "http://%s.ru", "ttl": 0}`, retStatus: http.StatusBadRequest},
+		{body: `{"url": "http://%s.ru", "ttl": true}`, retStatus: http.StatusBadRequest},
+	}
+	shorts := make([]string, 0)
+	for _, tt := range tests {
+		reqBody := fmt.Sprintf(tt.body, generateRandStr(20))
+		resp, err := http.Post("http://localhost:8080/api/shorten", "application/json", strings.NewReader(reqBody))
+		require.Nil(t, err)
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.Nil(t, err)
+		require.Equal(t, tt.retStatus, resp.StatusCode, reqBody)
+		if resp.StatusCode == http.StatusCreated {
+			res := map[string]string{}
+			require.Nil(t, json.Unmarshal(respBody, &res))
+			shorts = append(shorts, res[config.PostAPIresTag])
+		}
+	}
+
+	reqBody := fmt.Sprintf(`[{"correlation_id": "1", "original_url": "http://%s.ru", "ttl": 1}]`, generateRandStr(20))
+	resp, err := http.Post("http://localhost:8080/api/shorten/batch", "application/json", strings.NewReader(reqBody))
+	require.Nil(t, err)
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	res := make([]map[string]string, 0)
+	require.Nil(t, json.Unmarshal(respBody, &res))
+	require.Len(t, res, 1)
+	shorts = append(shorts, res[0]["short_url"])
+
+	time.Sleep(1100 * time.Millisecond)
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, short := range shorts {
+		resp, err := client.Get(short)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusGone, resp.StatusCode, short)
+	}
+}
+
+func endpointStatsTest(t *testing.T) {
+	newClient := func() *http.Client {
+		jar, _ := cookiejar.New(nil)
+		return &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	owner := newClient()
+	alias := "stats-" + generateRandStr(8)
+	reqBody, _ := json.Marshal(map[string]string{"url": "http://" + generateRandStr(20) + ".ru", "alias": alias})
+	resp, err := owner.Post("http://localhost:8080/api/shorten", "application/json", bytes.NewReader(reqBody))
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	const clicks = 3
+	for ik := 0; ik < clicks; ik++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/"+alias, nil)
+		req.Header.Set("Referer", "http://referrer.ru")
+		resp, err := newClient().Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	}
+	// clicks are saved in background
+	time.Sleep(1500 * time.Millisecond)
+
+	resp, err = owner.Get("http://localhost:8080/api/user/urls/" + alias + "/stats?bucket=hour")
+	require.Nil(t, err)
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	stats := model.ClickStats{}
+	require.Nil(t, json.Unmarshal(respBody, &stats))
+	assert.Equal(t, clicks, stats.Total)
+	assert.Equal(t, "hour", stats.Bucket)
+	assert.NotEmpty(t, stats.Buckets)
+
+	resp, err = newClient().Get("http://localhost:8080/api/user/urls/" + alias + "/stats")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = owner.Get("http://localhost:8080/api/user/urls/" + alias + "/stats?bucket=year")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func endpointDeleteJobTest(t *testing.T) {
+	jar, _ := cookiejar.New(nil)
+	owner := &http.Client{Jar: jar}
+	alias := "del-" + generateRandStr(8)
+	reqBody, _ := json.Marshal(map[string]string{"url": "http://" + generateRandStr(20) + ".ru", "alias": alias})
+	resp, err := owner.Post("http://localhost:8080/api/shorten", "application/json", bytes.NewReader(reqBody))
+	require.Nil(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	reqBody, _ = json.Marshal([]string{alias, "unknown-" + generateRandStr(8)})
+	req, _ := http.NewRequest(http.MethodDelete, "http://localhost:8080/api/user/urls", bytes.NewReader(reqBody))
+	resp, err = owner.Do(req)
+	require.Nil(t, err)
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Nil(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	accepted := map[string]string{}
+	require.Nil(t, json.Unmarshal(respBody, &accepted))
+	require.NotEmpty(t, accepted["job_id"])
+
+	job := model.DeleteJobStatus{}
+	require.Eventually(t, func() bool {
+		resp, err := owner.Get("http://localhost:8080/api/user/jobs/" + accepted["job_id"])
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		return err == nil && resp.StatusCode == http.StatusOK &&
+			json.Unmarshal(respBody, &job) == nil && job.Status == model.JobDone
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Equal(t, 2, job.Total)
+	assert.Equal(t, 2, job.Processed)
+	require.Len(t, job.Results, 2)
+	assert.Equal(t, model.DeleteDone, job.Results[0].Result)
+	assert.Equal(t, model.DeleteNotFound, job.Results[1].Result)
+
+	resp, err = http.Get("http://localhost:8080/api/user/jobs/" + accepted["job_id"])
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = owner.Get("http://localhost:8080/api/user/jobs/nosuchjob")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func endpointAuthTest(t *testing.T) {
+	newClient := func() *http.Client {
+		jar, _ := cookiejar.New(nil)
+		return &http.Client{Jar: jar}
+	}
+	post := func(client *http.Client, path string, body any) *http.Response {
+		reqBody, _ := json.Marshal(body)
+		resp, err := client.Post("http://localhost:8080"+path, "application/json", bytes.NewReader(reqBody))
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	countURLs := func(client *http.Client) int {
+		resp, err := client.Get("http://localhost:8080/api/user/urls")
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		urls := make([]map[string]string, 0)
+		if resp.StatusCode == http.StatusOK {
+			require.Nil(t, json.NewDecoder(resp.Body).Decode(&urls))
+		}
+		return len(urls)
+	}
+	account := map[string]string{"email": generateRandStr(10) + "@example.com", "password": generateRandStr(12)}
+
+	browser := newClient()
+	assert.Equal(t, http.StatusCreated, post(browser, "/api/shorten", map[string]string{"url": "http://" + generateRandStr(20) + ".ru"}).StatusCode)
+	assert.Equal(t, http.StatusCreated, post(browser, "/api/auth/register", account).StatusCode)
+	assert.Equal(t, 1, countURLs(browser))
+	assert.Equal(t, http.StatusConflict, post(newClient(), "/api/auth/register", account).StatusCode)
+
+	// cookies cleared, links are back after login
+	assert.Equal(t, http.StatusOK, post(browser, "/api/auth/logout", nil).StatusCode)
+	assert.Equal(t, 0, countURLs(browser))
+	another := newClient()
+	assert.Equal(t, http.StatusUnauthorized, post(another, "/api/auth/login", map[string]string{"email": account["email"], "password": "wrong"}).StatusCode)
+	assert.Equal(t, http.StatusOK, post(another, "/api/auth/login", account).StatusCode)
+	assert.Equal(t, 1, countURLs(another))
+
+	// form of another site can't log in or out
+	for _, path := range []string{"/api/auth/login", "/api/auth/logout"} {
+		form := strings.NewReader("email=" + account["email"] + "&password=" + account["password"])
+		resp, err := another.Post("http://localhost:8080"+path, "application/x-www-form-urlencoded", form)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode, path)
+	}
+	assert.Equal(t, 1, countURLs(another))
+}
+
+func endpointAPIKeyTest(t *testing.T) {
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar}
+	resp, err := browser.Post("http://localhost:8080/api/user/keys", "application/json", strings.NewReader(`{"name":"ci"}`))
+	require.Nil(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	key := map[string]string{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&key))
+	resp.Body.Close()
+
+	// no cookies, user is known by key
+	batch := func(apiKey string) int {
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/api/shorten/batch",
+			strings.NewReader(`[{"correlation_id":"1","original_url":"http://`+generateRandStr(20)+`.ru"}]`))
+		req.Header.Set("X-API-Key", apiKey)
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Empty(t, resp.Cookies())
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusCreated, batch(key["key"]))
+	assert.Equal(t, http.StatusUnauthorized, batch(key["key"]+"0"))
+	resp, err = browser.Get("http://localhost:8080/api/user/urls")
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodDelete, "http://localhost:8080/api/user/keys/"+key["id"], nil)
+	resp, err = browser.Do(req)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, batch(key["key"]))
+}
+
+func endpointAdminTest(t *testing.T) {
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/api/admin/urls?q=ru"},
+		{http.MethodDelete, "/api/admin/urls/abcde"},
+		{http.MethodGet, "/api/admin/audit"},
+		{http.MethodPost, "/api/admin/reload"},
+	} {
+		request, _ := http.NewRequest(req.method, "http://localhost:8080"+req.path, nil)
+		resp, err := http.DefaultClient.Do(request)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, req.path)
+	}
+}
+
+// Trusted subnet is not set in tests, so nobody is trusted
+func endpointInternalStatsTest(t *testing.T) {
+	for _, realIP := range []string{"", "127.0.0.1", "10.0.0.1"} {
+		request, _ := http.NewRequest(http.MethodGet, "http://localhost:8080/api/internal/stats", nil)
+		if realIP != "" {
+			request.Header.Set("X-Real-IP", realIP)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		require.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, realIP)
+	}
+}
+
+func grpcTest(t *testing.T) {
+	conn, err := grpc.Dial("localhost:3200", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	defer conn.Close()
+	client := pb.NewShortenerClient(conn)
+	ctx := context.Background()
+
+	// new user gets sealed user id in header
+	header := metadata.MD{}
+	url := "http://" + generateRandStr(20) + ".ru"
+	short, err := client.Shorten(ctx, &pb.ShortenRequest{Url: url}, grpc.Header(&header))
+	require.Nil(t, err)
+	assert.False(t, short.Existed)
+	require.Len(t, header.Get(config.UserIDMeta), 1)
+	ctx = metadata.AppendToOutgoingContext(ctx, config.UserIDMeta, header.Get(config.UserIDMeta)[0])
+
+	again, err := client.Shorten(ctx, &pb.ShortenRequest{Url: url})
+	require.Nil(t, err)
+	assert.True(t, again.Existed)
+	assert.Equal(t, short.ShortUrl, again.ShortUrl)
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "not url"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	batch, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Urls: []*pb.ShortenBatchRequest_URL{
+		{CorrelationId: "1", OriginalUrl: "http://" + generateRandStr(20) + ".ru"},
+	}})
+	require.Nil(t, err)
+	require.Len(t, batch.Urls, 1)
+	assert.Equal(t, "1", batch.Urls[0].CorrelationId)
+
+	id := short.ShortUrl[strings.LastIndex(short.ShortUrl, "/")+1:]
+	resolved, err := client.Resolve(ctx, &pb.ResolveRequest{ShortId: id})
+	require.Nil(t, err)
+	assert.Equal(t, url, resolved.OriginalUrl)
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{ShortId: "no-such-id"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	list, err := client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	require.Nil(t, err)
+	assert.Len(t, list.Urls, 2)
+
+	deleted, err := client.DeleteUserURLs(ctx, &pb.DeleteUserURLsRequest{ShortUrls: []string{short.ShortUrl}})
+	require.Nil(t, err)
+	assert.NotEmpty(t, deleted.JobId)
+
+	_, err = client.Ping(ctx, &pb.PingRequest{})
+	assert.Nil(t, err)
+	_, err = client.Stats(ctx, &pb.StatsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// Hammers all endpoints at the same time, run it with -race flag
+// to check in-memory index for data races.
+func concurrentStressTest(t *testing.T) {
+	const clients = 8
+	const rounds = 20
+
+	var wg sync.WaitGroup
+	for ik := 0; ik < clients; ik++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jar, _ := cookiejar.New(nil)
+			client := &http.Client{
+				Jar: jar,
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}
+			shorts := make([]string, 0)
+			for jk := 0; jk < rounds; jk++ {
+				URL := fmt.Sprintf("http://%s.%s", generateRandStr(20), generateRandStr(3))
+				resp, err := client.Post("http://localhost:8080/", "text/plain", strings.NewReader(URL))
+				if !assert.Nil(t, err) {
+					return
+				}
+				short, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				assert.Equal(t, http.StatusCreated, resp.StatusCode)
+				shorts = append(shorts, string(short))
+
+				// same url from api is duplicate
+				reqBody, _ := json.Marshal(map[string]string{"url": URL})
+				resp, err = client.Post("http://localhost:8080/api/shorten", "application/json", bytes.NewReader(reqBody))
+				if !assert.Nil(t, err) {
+					return
+				}
+				resp.Body.Close()
+				assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+				batch := []map[string]string{
+					{"correlation_id": "1", "original_url": fmt.Sprintf("http://%s.%s", generateRandStr(20), generateRandStr(3))},
+					{"correlation_id": "2", "original_url": fmt.Sprintf("http://%s.%s", generateRandStr(20), generateRandStr(3))},
+				}
+				reqBody, _ = json.Marshal(batch)
+				resp, err = client.Post("http://localhost:8080/api/shorten/batch", "application/json", bytes.NewReader(reqBody))
+				if !assert.Nil(t, err) {
+					return
+				}
+				resp.Body.Close()
+				assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+				resp, err = client.Get(string(short))
+				if !assert.Nil(t, err) {
+					return
+				}
+				resp.Body.Close()
+				assert.Contains(t, []int{http.StatusTemporaryRedirect, http.StatusGone}, resp.StatusCode)
+
+				resp, err = client.Get("http://localhost:8080/api/user/urls")
+				if !assert.Nil(t, err) {
+					return
+				}
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				if jk%5 == 4 {
+					reqBody, _ = json.Marshal(shorts)
+					req, _ := http.NewRequest(http.MethodDelete, "http://localhost:8080/api/user/urls", bytes.NewReader(reqBody))
+					resp, err = client.Do(req)
+					if !assert.Nil(t, err) {
+						return
+					}
+					resp.Body.Close()
+					assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+				}
+
+				resp, err = client.Get("http://localhost:8080/info")
+				if !assert.Nil(t, err) {
+					return
+				}
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func generateRandStr(l int) string {
 	var availChars = []byte("abcdefghijklmnopqrstuvwxyz")
 
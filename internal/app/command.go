@@ -0,0 +1,26 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+)
+
+// Runs subcommand given after flags instead of serving, e.g.
+//
+//	shortener -d postgres://... migrate up
+//	shortener -f urls.stor compact
+//	shortener -f urls.stor admin grant user@example.com
+func Command(c *config.Config, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return repository.Migrate(context.Background(), c, args[1:])
+	case "compact":
+		return repository.New(c).Compact(context.Background())
+	case "admin":
+		return repository.AdminCommand(context.Background(), c, args[1:])
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
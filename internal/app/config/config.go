@@ -3,69 +3,196 @@ package config
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"log"
-	"strings"
-
-	"github.com/caarlos0/env/v7"
+	"os"
+	"time"
 )
 
+// Every field is set by flag, env var and config file key given in its
+// tags, see load for precedence. Fields tagged reload are applied on
+// config reload, see Runtime.
 type Config struct {
-	Listen       string `env:"SERVER_ADDRESS"`
-	HostName     string `env:"BASE_URL"`
-	FileStorage  string `env:"FILE_STORAGE_PATH"`
-	PgConnString string `env:"DATABASE_DSN"`
-	LenShortURL  int    `env:"SHORTLEN"`
-	RetShrtWHost bool   `env:"ADDHOST" envDefault:"true"`
+	Listen        string        `env:"SERVER_ADDRESS" json:"server_address" flag:"a"`
+	HostName      string        `env:"BASE_URL" json:"base_url" flag:"b"`
+	FileStorage   string        `env:"FILE_STORAGE_PATH" json:"file_storage_path" flag:"f"`
+	PgConnString  string        `env:"DATABASE_DSN" json:"database_dsn" flag:"d"`
+	LenShortURL   int           `env:"SHORTLEN" json:"shortlen" flag:"l"`
+	RetShrtWHost  bool          `env:"ADDHOST" json:"addhost" flag:"addhost" reload:"true"`
+	ShortGen      string        `env:"SHORTGEN" json:"shortgen" flag:"g"`
+	ShortAlpha    string        `env:"SHORTALPHABET" json:"shortalphabet" flag:"alphabet"`
+	ShortSalt     string        `env:"SHORTSALT" json:"shortsalt" flag:"salt"`
+	ReapInterval  time.Duration `env:"REAP_INTERVAL" json:"reap_interval" flag:"reap"`
+	ClickBuffer   int           `env:"CLICK_BUFFER" json:"click_buffer" flag:"clickbuf"`
+	ClickFlush    time.Duration `env:"CLICK_FLUSH" json:"click_flush" flag:"clickflush"`
+	LazyLoad      bool          `env:"LAZY_LOAD" json:"lazy_load" flag:"lazy"`
+	CacheSize     int           `env:"CACHE_SIZE" json:"cache_size" flag:"cache"`
+	CacheNegTTL   time.Duration `env:"CACHE_NEG_TTL" json:"cache_neg_ttl" flag:"cachenegttl"`
+	CacheMetrics  time.Duration `env:"CACHE_METRICS" json:"cache_metrics" flag:"cachemetrics"`
+	CompactSize   int64         `env:"COMPACT_MIN_SIZE" json:"compact_min_size" flag:"compactsize"`
+	CompactRatio  float64       `env:"COMPACT_RATIO" json:"compact_ratio" flag:"compactratio"`
+	Fsync         string        `env:"FSYNC" json:"fsync" flag:"fsync"`
+	FsyncEvery    time.Duration `env:"FSYNC_INTERVAL" json:"fsync_interval" flag:"fsyncinterval"`
+	WALSegment    int64         `env:"WAL_SEGMENT_SIZE" json:"wal_segment_size" flag:"walsegment"`
+	DeleteWorkers int           `env:"DELETE_WORKERS" json:"delete_workers" flag:"delworkers"`
+	DeleteRetries int           `env:"DELETE_RETRIES" json:"delete_retries" flag:"delretries"`
+	DeleteTimeout time.Duration `env:"DELETE_TIMEOUT" json:"delete_timeout" flag:"deltimeout"`
+	CookieSecret  string        `env:"COOKIE_SECRET" json:"cookie_secret" flag:"secret"`
+	CookieKeyFile string        `env:"COOKIE_KEY_FILE" json:"cookie_key_file" flag:"keyfile"`
+	JWTSecret     string        `env:"JWT_SECRET" json:"jwt_secret" flag:"jwtsecret"`
+	JWTPrivateKey string        `env:"JWT_PRIVATE_KEY" json:"jwt_private_key" flag:"jwtkey"`
+	JWTPublicKey  string        `env:"JWT_PUBLIC_KEY" json:"jwt_public_key" flag:"jwtpubkey"`
+	JWTIssuer     string        `env:"JWT_ISSUER" json:"jwt_issuer" flag:"jwtissuer"`
+	JWTTTL        time.Duration `env:"JWT_TTL" json:"jwt_ttl" flag:"jwtttl"`
+	Admins        string        `env:"ADMIN_USERS" json:"admin_users" flag:"admins" reload:"true"`
+	TrustedSubnet string        `env:"TRUSTED_SUBNET" json:"trusted_subnet" flag:"t"`
+	RateLimits    string        `env:"RATE_LIMITS" json:"rate_limits" flag:"ratelimits" reload:"true"`
+	BlockedHosts  string        `env:"BLOCKED_HOSTS" json:"blocked_hosts" flag:"blocked" reload:"true"`
+	ShutdownTime  time.Duration `env:"SHUTDOWN_TIMEOUT" json:"shutdown_timeout" flag:"shutdown"`
+	EnableHTTPS   bool          `env:"ENABLE_HTTPS" json:"enable_https" flag:"s"`
+	TLSCertFile   string        `env:"TLS_CERT_FILE" json:"tls_cert_file" flag:"cert"`
+	TLSKeyFile    string        `env:"TLS_KEY_FILE" json:"tls_key_file" flag:"key"`
+	HTTPRedirect  string        `env:"HTTP_REDIRECT_ADDRESS" json:"http_redirect_address" flag:"redirect"`
+	GRPCListen    string        `env:"GRPC_ADDRESS" json:"grpc_address" flag:"grpc"`
+
+	flags []string // given on command line, to replay them on reload
 }
 
 const (
 	PostAPIreqTag string = "url"
 	PostAPIresTag string = "result"
+	AliasTag      string = "alias"
+	TTLTag        string = "ttl"
+	ExpiresTag    string = "expires_at"
+	EmailTag      string = "email"
+	PasswordTag   string = "password"
+	KeyNameTag    string = "name"
+	UserIDTag     string = "user_id"
 	CookieName    string = "ShrtnrUserID"
-	PassCiph      string = "AF12345"
+	TokenHeader   string = "Authorization"
+	APIKeyHeader  string = "X-API-Key"
+	UserIDMeta    string = "user-id" // gRPC metadata with sealed user id, as in cookie
+)
+
+// Short id generators
+const (
+	GenRandom  string = "random"
+	GenCounter string = "counter"
+	GenHash    string = "hash"
+	GenSqids   string = "sqids"
+
+	DefaultAlphabet string = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// short column in db is VARCHAR(20)
+	MaxLenShortURL int = 20
+	MinLenAlias    int = 3
 )
 
+// When file storage calls fsync on its write-ahead log
+const (
+	FsyncAlways   string = "always"
+	FsyncInterval string = "interval"
+	FsyncNever    string = "never"
+)
+
+// Time buckets of click stats
+var StatBuckets = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+const DefaultStatBucket = "day"
+
+// Short ids that can't be used as alias, as they clash with routes
+var ReservedAliases = []string{"ping", "info", "api", "admin", "user", "auth", "jobs", "static", "health"}
+
 type ctxKey int
 
 const (
-	ContextKeyUserID ctxKey = 1
+	ContextKeyUserID   ctxKey = 1
+	ContextKeyPeerAddr ctxKey = 2
 )
 
+// Reads config from flags, environment and config file, see load.
+// Exits on invalid config.
 func New() *Config {
-	c := &Config{}
-	err := env.Parse(c)
+	c, err := load(flag.CommandLine, os.Args[1:], os.Getenv)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if c.Listen == "" {
-		flag.StringVar(&c.Listen, "a", ":8080", "HTTP listen addr")
-	}
-	if c.HostName == "" {
-		flag.StringVar(&c.HostName, "b", "http://localhost:8080", "Host name in short URL")
-	}
-	if c.FileStorage == "" {
-		flag.StringVar(&c.FileStorage, "f", "", "File to store. If omitted no files will created")
-	}
-	if c.PgConnString == "" {
-		flag.StringVar(&c.PgConnString, "d", "", "Postgres connect URL")
-	}
-	if c.LenShortURL == 0 {
-		flag.IntVar(&c.LenShortURL, "l", 5, "Length of short address")
-	}
-	flag.Parse()
-	if !strings.HasSuffix(c.HostName, "/") {
-		c.HostName = c.HostName + "/"
-	}
 	return c
 }
 
+// Registers flag of every field, flag default is default of field
+func (c *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.Listen, "a", ":8080", "HTTP listen addr")
+	fs.StringVar(&c.HostName, "b", "http://localhost:8080", "Host name in short URL")
+	fs.StringVar(&c.FileStorage, "f", "", "File to store. If omitted no files will created")
+	fs.StringVar(&c.PgConnString, "d", "", "Postgres connect URL")
+	fs.IntVar(&c.LenShortURL, "l", 5, "Length of short address")
+	fs.BoolVar(&c.RetShrtWHost, "addhost", true, "Return short url with host name, not just short id")
+	fs.StringVar(&c.ShortGen, "g", GenRandom, "Short id generator: random, counter, hash or sqids")
+	fs.StringVar(&c.ShortAlpha, "alphabet", DefaultAlphabet, "Chars for random short id generator")
+	fs.StringVar(&c.ShortSalt, "salt", "", "Salt for hash and sqids short id generators")
+	fs.DurationVar(&c.ReapInterval, "reap", time.Minute, "Interval of expired links check")
+	fs.IntVar(&c.ClickBuffer, "clickbuf", 1024, "Size of buffer for not saved clicks")
+	fs.DurationVar(&c.ClickFlush, "clickflush", time.Second, "Interval of saving clicks")
+	fs.BoolVar(&c.LazyLoad, "lazy", false, "Don't load all records at start, read them from postgres through cache")
+	fs.IntVar(&c.CacheSize, "cache", 10000, "Max records in cache in lazy mode")
+	fs.DurationVar(&c.CacheNegTTL, "cachenegttl", time.Minute, "How long cache remembers absent records in lazy mode")
+	fs.DurationVar(&c.CacheMetrics, "cachemetrics", 0, "Interval of logging cache metrics in lazy mode, 0 is off")
+	fs.Int64Var(&c.CompactSize, "compactsize", 1<<20, "Min size of storage log to compact it, 0 is never")
+	fs.Float64Var(&c.CompactRatio, "compactratio", 2, "Compact storage file when it holds that many copies per record")
+	fs.StringVar(&c.Fsync, "fsync", FsyncInterval, "When storage log is synced to disk: always, interval or never")
+	fs.DurationVar(&c.FsyncEvery, "fsyncinterval", time.Second, "Interval of storage log sync in interval mode")
+	fs.Int64Var(&c.WALSegment, "walsegment", 4<<20, "Max size of storage log segment file")
+	fs.IntVar(&c.DeleteWorkers, "delworkers", 4, "Number of workers running deletion jobs")
+	fs.IntVar(&c.DeleteRetries, "delretries", 10, "Attempts of deletion job before it is given up")
+	fs.DurationVar(&c.DeleteTimeout, "deltimeout", time.Minute, "Max time of one attempt of deletion job")
+	fs.StringVar(&c.CookieSecret, "secret", "", "Cookie secret, or list of id:secret keys, the first one is active")
+	fs.StringVar(&c.CookieKeyFile, "keyfile", "", "File with id:secret cookie keys, one per line, the first one is active")
+	fs.StringVar(&c.JWTSecret, "jwtsecret", "", "Secret of HS256 bearer tokens")
+	fs.StringVar(&c.JWTPrivateKey, "jwtkey", "", "PEM file with RSA private key of RS256 bearer tokens")
+	fs.StringVar(&c.JWTPublicKey, "jwtpubkey", "", "PEM file with RSA public key to check RS256 bearer tokens issued elsewhere")
+	fs.StringVar(&c.JWTIssuer, "jwtissuer", "shortener", "Issuer of bearer tokens")
+	fs.DurationVar(&c.JWTTTL, "jwtttl", 30*24*time.Hour, "Lifetime of issued bearer tokens")
+	fs.StringVar(&c.Admins, "admins", "", "Comma separated user ids with admin role")
+	fs.StringVar(&c.TrustedSubnet, "t", "", "CIDR of subnet allowed to see internal stats")
+	fs.StringVar(&c.RateLimits, "ratelimits", DefaultRateLimits, "Rate limits by route group (create, auth, api, redirect): group=N/period[:burst],...")
+	fs.StringVar(&c.BlockedHosts, "blocked", "", "Comma separated hosts, urls of them and of their subdomains are not shortened")
+	fs.DurationVar(&c.ShutdownTime, "shutdown", 10*time.Second, "Time to drain requests, and then to flush queues, on shutdown")
+	fs.BoolVar(&c.EnableHTTPS, "s", false, "Serve HTTPS, self-signed certificate is made if cert and key are omitted")
+	fs.StringVar(&c.TLSCertFile, "cert", "", "PEM file with TLS certificate")
+	fs.StringVar(&c.TLSKeyFile, "key", "", "PEM file with TLS private key")
+	fs.StringVar(&c.HTTPRedirect, "redirect", "", "HTTP listen addr redirecting to HTTPS, if omitted there is no redirect")
+	fs.StringVar(&c.GRPCListen, "grpc", "", "gRPC listen addr, if omitted gRPC is not served")
+}
+
 var (
-	ErrNoSuchRecord   = errors.New("no such record")
-	ErrInvalidReqBody = errors.New("invalid request body")
-	ErrEmptyReqBody   = errors.New("empty request body")
-	ErrURLNotCorrect  = errors.New("given url is not correct")
-	ErrNoFreeIDs      = errors.New("no free short url")
-	ErrInvalidGZip    = errors.New("error in gzipped request")
-	ErrDuplicateURL   = errors.New("duplicate url")
-	ErrURLDeleted     = errors.New("deleted url")
+	ErrNoSuchRecord    = errors.New("no such record")
+	ErrInvalidReqBody  = errors.New("invalid request body")
+	ErrEmptyReqBody    = errors.New("empty request body")
+	ErrURLNotCorrect   = errors.New("given url is not correct")
+	ErrNoFreeIDs       = errors.New("no free short url")
+	ErrInvalidGZip     = errors.New("error in gzipped request")
+	ErrDuplicateURL    = errors.New("duplicate url")
+	ErrURLDeleted      = errors.New("deleted url")
+	ErrAliasNotValid   = errors.New(fmt.Sprintf("alias must be %d-%d latin letters, digits, '-' or '_'", MinLenAlias, MaxLenShortURL))
+	ErrAliasReserved   = errors.New("alias is reserved")
+	ErrAliasTaken      = errors.New("alias already taken")
+	ErrURLHasShort     = errors.New("url is already shortened, alias can not be given to it")
+	ErrURLExpired      = errors.New("expired url")
+	ErrNotOwner        = errors.New("only owner can")
+	ErrBucketNotValid  = errors.New("bucket must be minute, hour or day")
+	ErrEmailTaken      = errors.New("email already registered")
+	ErrBadCredentials  = errors.New("wrong email or password")
+	ErrAccountNotValid = errors.New("email must be valid address, password must be 8-72 chars")
+	ErrAPIKeyNotValid  = errors.New("api key is not valid or revoked")
+	ErrKeyNameNotValid = errors.New("key name must be 1-64 chars")
+	ErrNotAdmin        = errors.New("admin role is needed")
+	ErrNotJSON         = errors.New("content type must be application/json")
+	ErrUserIDNotValid  = errors.New("user id must be 32 lowercase hex chars")
+	ErrURLBlocked      = errors.New("host of url is blocked")
+	ErrFilterNotValid  = errors.New("deleted must be true or false, limit and offset must be non-negative numbers")
+	ErrExpiryNotValid  = errors.New("ttl must be positive duration (\"72h\") or seconds, expires_at must be RFC3339 time in future")
 )
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config is put together from four sources, each one overrides the
+// ones below it:
+//
+//  1. flags
+//  2. env vars, empty one counts as not set
+//  3. JSON config file given by -c or CONFIG
+//  4. defaults of flags
+//
+// Config file is flat object, keys are env var names in lower case:
+//
+//	{"server_address": ":8443", "enable_https": true, "shutdown_timeout": "30s"}
+//
+// Values from every source are parsed the same way as flags, so
+// durations are "30s" strings in config file too.
+
+const ConfigFileEnv = "CONFIG"
+
+// Field of config and where its value comes from
+type option struct {
+	flag *flag.Flag
+	env  string
+	key  string
+	from string // source and raw value, for error messages
+}
+
+func load(fs *flag.FlagSet, args []string, getenv func(string) string) (*Config, error) {
+	c := &Config{}
+	c.register(fs)
+	configFile := fs.String("c", "", "JSON config file, keys are env var names in lower case")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\nflags override env vars, env vars override config file, config file overrides defaults\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	byFlag := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) {
+		byFlag[fl.Name] = true
+	})
+	opts := c.options(fs, byFlag)
+	c.flags = replayFlags(fs, opts)
+
+	path := *configFile
+	if path == "" {
+		path = getenv(ConfigFileEnv)
+	}
+	if path != "" {
+		if err := loadFile(path, opts, byFlag); err != nil {
+			return nil, err
+		}
+	}
+	for _, opt := range opts {
+		value := getenv(opt.env)
+		if value == "" || byFlag[opt.flag.Name] {
+			continue
+		}
+		opt.from = fmt.Sprintf("env %s=%q", opt.env, value)
+		if err := opt.flag.Value.Set(value); err != nil {
+			return nil, fmt.Errorf("%s: %w", opt.from, err)
+		}
+	}
+
+	if err := c.validate(opts); err != nil {
+		return nil, err
+	}
+	c.normalize()
+	return c, nil
+}
+
+// Options by pointer to field. Every field must have flag, it is a bug
+// otherwise.
+func (c *Config) options(fs *flag.FlagSet, byFlag map[string]bool) map[any]*option {
+	v := reflect.ValueOf(c).Elem()
+	res := make(map[any]*option, v.NumField())
+	for ik := 0; ik < v.NumField(); ik++ {
+		field := v.Type().Field(ik)
+		if !field.IsExported() {
+			continue
+		}
+		fl := fs.Lookup(field.Tag.Get("flag"))
+		if fl == nil {
+			panic(fmt.Sprintf("config field %s has no flag", field.Name))
+		}
+		opt := &option{
+			flag: fl,
+			env:  field.Tag.Get("env"),
+			key:  field.Tag.Get("json"),
+			from: fmt.Sprintf("default -%s=%q", fl.Name, fl.DefValue),
+		}
+		if byFlag[fl.Name] {
+			opt.from = fmt.Sprintf("flag -%s=%q", fl.Name, fl.Value.String())
+		}
+		res[v.Field(ik).Addr().Interface()] = opt
+	}
+	return res
+}
+
+// Config flags given on command line, flags of others (like tests) are
+// left out
+func replayFlags(fs *flag.FlagSet, opts map[any]*option) []string {
+	ours := map[string]bool{"c": true}
+	for _, opt := range opts {
+		ours[opt.flag.Name] = true
+	}
+	res := make([]string, 0)
+	fs.Visit(func(fl *flag.Flag) {
+		if ours[fl.Name] {
+			res = append(res, "-"+fl.Name+"="+fl.Value.String())
+		}
+	})
+	return res
+}
+
+// Sets fields not given by flags from config file. Unknown keys are
+// errors, most likely they are typos.
+func loadFile(path string, opts map[any]*option, byFlag map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	values := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	byKey := make(map[string]*option, len(opts))
+	for _, opt := range opts {
+		byKey[opt.key] = opt
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		opt, found := byKey[key]
+		if !found {
+			return fmt.Errorf("config file %s: unknown key %q", path, key)
+		}
+		if byFlag[opt.flag.Name] {
+			continue
+		}
+		raw := values[key]
+		opt.from = fmt.Sprintf("config file %s, %q: %s", path, key, raw)
+		value, err := jsonValue(raw)
+		if err == nil {
+			err = opt.flag.Value.Set(value)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", opt.from, err)
+		}
+	}
+	return nil
+}
+
+// Value of config file key as flag would get it
+func jsonValue(raw json.RawMessage) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+	return "", errors.New("value must be string, number or bool")
+}
+
+// Final touches of valid config
+func (c *Config) normalize() {
+	if c.EnableHTTPS && strings.HasPrefix(c.HostName, "http://") {
+		// short urls are given with the scheme they are served with
+		c.HostName = "https://" + strings.TrimPrefix(c.HostName, "http://")
+	}
+	if !strings.HasSuffix(c.HostName, "/") {
+		c.HostName = c.HostName + "/"
+	}
+}
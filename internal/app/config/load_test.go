@@ -0,0 +1,140 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLoad(args []string, env map[string]string) (*Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return load(fs, args, func(name string) string {
+		return env[name]
+	})
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"shortlen": 7,
+		"base_url": "http://short.example.com",
+		"server_address": ":9090",
+		"lazy_load": false,
+		"shutdown_timeout": "30s"
+	}`)
+	c, err := testLoad([]string{"-l", "9"}, map[string]string{
+		ConfigFileEnv:    path,
+		"SHORTLEN":       "8",
+		"SERVER_ADDRESS": ":9191",
+		"BASE_URL":       "",
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 9, c.LenShortURL, "flag over env and file")
+	assert.Equal(t, ":9191", c.Listen, "env over file")
+	assert.Equal(t, "http://short.example.com/", c.HostName, "file over default, empty env is not set")
+	assert.Equal(t, 30*time.Second, c.ShutdownTime)
+	assert.Equal(t, FsyncInterval, c.Fsync, "default")
+	assert.True(t, c.RetShrtWHost, "default")
+
+	c, err = testLoad([]string{"-c", path, "-s"}, nil)
+	require.Nil(t, err)
+	assert.Equal(t, 7, c.LenShortURL)
+	assert.Equal(t, "https://short.example.com/", c.HostName, "base url follows https")
+}
+
+func TestLoad_Errors(t *testing.T) {
+	path := writeConfigFile(t, `{"server_address": "localhost", "shortlen": 5}`)
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "env",
+			env:  map[string]string{"SHORTLEN": "25"},
+			want: []string{`env SHORTLEN="25": length of short address must be in 1..20`},
+		},
+		{
+			name: "flag",
+			args: []string{"-b", "ftp://short.example.com"},
+			want: []string{`flag -b="ftp://short.example.com": base url must be`},
+		},
+		{
+			name: "config file",
+			args: []string{"-c", path},
+			want: []string{`config file ` + path + `, "server_address": "localhost": listen addr must be`},
+		},
+		{
+			name: "all errors",
+			args: []string{"-g", "uuid", "-redirect", ":80"},
+			env:  map[string]string{"DELETE_WORKERS": "0"},
+			want: []string{
+				`flag -g="uuid": unknown short id generator`,
+				`env DELETE_WORKERS="0": must be positive`,
+				`flag -redirect=":80": HTTP redirect needs HTTPS`,
+			},
+		},
+		{
+			name: "alphabet",
+			args: []string{"-alphabet", "abca"},
+			want: []string{`flag -alphabet="abca": alphabet must have at least 2 unique chars`},
+		},
+		{
+			name: "alphabet chars",
+			env:  map[string]string{"SHORTALPHABET": "ab/c"},
+			want: []string{`env SHORTALPHABET="ab/c": alphabet must have at least 2 unique chars`},
+		},
+		{
+			name: "not parsed",
+			env:  map[string]string{"CLICK_FLUSH": "10"},
+			want: []string{`env CLICK_FLUSH="10": `},
+		},
+		{
+			name: "unknown key",
+			args: []string{"-c", writeConfigFile(t, `{"shortlength": 5}`)},
+			want: []string{`unknown key "shortlength"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testLoad(tt.args, tt.env)
+			require.NotNil(t, err)
+			for _, want := range tt.want {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
+
+func TestConfig_Reload(t *testing.T) {
+	path := writeConfigFile(t, `{"addhost": true, "rate_limits": "create=10/s", "blocked_hosts": "spam.ru", "shortlen": 5}`)
+	// flags of tests are in the same flag set, they are not replayed
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("test.v", false, "")
+	c, err := load(fs, []string{"-c", path, "-admins", "root", "-test.v"}, func(string) string { return "" })
+	require.Nil(t, err)
+	assert.Equal(t, Runtime{RetShrtWHost: true, Admins: "root", RateLimits: "create=10/s", BlockedHosts: "spam.ru"}, c.Runtime())
+
+	require.Nil(t, os.WriteFile(path, []byte(`{"addhost": false, "admin_users": "other", "rate_limits": "", "shortlen": 6}`), 0600))
+	next, err := c.Reload()
+	require.Nil(t, err)
+	assert.Equal(t, Runtime{RetShrtWHost: false, Admins: "root", RateLimits: ""}, next.Runtime(), "flags are replayed")
+	assert.Equal(t, []string{"shortlen"}, c.NeedRestart(next))
+
+	require.Nil(t, os.WriteFile(path, []byte(`{"rate_limits": "create=10"}`), 0600))
+	_, err = c.Reload()
+	assert.ErrorContains(t, err, `"rate_limits": "create=10"`)
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rate limits are given per route group as "group=N/period[:burst]"
+// list, e.g. "create=60/m:20,auth=10/m". Period is s, m or h, burst is
+// N if omitted. Group without limit is not limited, by default nothing
+// is limited.
+
+// Route groups of rate limiter
+const (
+	RateCreate   string = "create"   // new short urls
+	RateAuth     string = "auth"     // register and login
+	RateAPI      string = "api"      // other user api
+	RateRedirect string = "redirect" // redirects by short url
+)
+
+const DefaultRateLimits = ""
+
+var ratePeriods = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+}
+
+type RateLimit struct {
+	Rate   float64 // tokens per second
+	Burst  int
+	Period time.Duration
+}
+
+// Zero limit means no limit
+func (rl RateLimit) IsZero() bool {
+	return rl.Burst == 0
+}
+
+func ParseRateLimits(list string) (map[string]RateLimit, error) {
+	res := make(map[string]RateLimit)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, limit, found := strings.Cut(entry, "=")
+		switch group {
+		case RateCreate, RateAuth, RateAPI, RateRedirect:
+		default:
+			return nil, fmt.Errorf("rate limit %q: unknown route group %q", entry, group)
+		}
+		if !found {
+			return nil, fmt.Errorf("rate limit %q must be group=N/period[:burst]", entry)
+		}
+		rl, err := parseRateLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit %q: %w", entry, err)
+		}
+		res[group] = rl
+	}
+	return res, nil
+}
+
+func parseRateLimit(limit string) (RateLimit, error) {
+	limit, burstStr, hasBurst := strings.Cut(limit, ":")
+	countStr, periodStr, found := strings.Cut(limit, "/")
+	count, err := strconv.Atoi(countStr)
+	if !found || err != nil || count < 1 {
+		return RateLimit{}, fmt.Errorf("rate must be positive N/period")
+	}
+	period, ok := ratePeriods[periodStr]
+	if !ok {
+		return RateLimit{}, fmt.Errorf("period must be s, m or h")
+	}
+	burst := count
+	if hasBurst {
+		burst, err = strconv.Atoi(burstStr)
+		if err != nil || burst < 1 {
+			return RateLimit{}, fmt.Errorf("burst must be positive number")
+		}
+	}
+	return RateLimit{
+		Rate:   float64(count) / period.Seconds(),
+		Burst:  burst,
+		Period: period,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"reflect"
+)
+
+// Part of config applied without restart. On SIGHUP or by admin request
+// config is read again from flags given at start, env and config file,
+// and its Runtime is handed to components. Changes of other fields are
+// only reported, they need restart. Log level is not reloadable, as
+// service logs by standard log without levels.
+
+type Runtime struct {
+	RetShrtWHost bool   `json:"addhost"`
+	Admins       string `json:"admin_users"`
+	RateLimits   string `json:"rate_limits"`
+	BlockedHosts string `json:"blocked_hosts"`
+}
+
+func (c *Config) Runtime() Runtime {
+	return Runtime{
+		RetShrtWHost: c.RetShrtWHost,
+		Admins:       c.Admins,
+		RateLimits:   c.RateLimits,
+		BlockedHosts: c.BlockedHosts,
+	}
+}
+
+// Reads config again, invalid config is an error as on start
+func (c *Config) Reload() (*Config, error) {
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return load(fs, c.flags, os.Getenv)
+}
+
+// Returns config file keys of fields changed in other config, which
+// are not applied until restart
+func (c *Config) NeedRestart(other *Config) []string {
+	v, ov := reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem()
+	res := make([]string, 0)
+	for ik := 0; ik < v.NumField(); ik++ {
+		field := v.Type().Field(ik)
+		if !field.IsExported() || field.Tag.Get("reload") != "" {
+			continue
+		}
+		if !v.Field(ik).Equal(ov.Field(ik)) {
+			res = append(res, field.Tag.Get("json"))
+		}
+	}
+	return res
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Every invalid field is reported with the source of its value
+type validator struct {
+	opts map[any]*option
+	errs []error
+}
+
+// field is pointer to field of config
+func (v *validator) check(field any, ok bool, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s: %s", v.opts[field].from, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (v *validator) checkErr(field any, err error) {
+	if err != nil {
+		v.errs = append(v.errs, fmt.Errorf("%s: %w", v.opts[field].from, err))
+	}
+}
+
+func (c *Config) validate(opts map[any]*option) error {
+	v := &validator{opts: opts}
+	v.check(&c.Listen, validListen(c.Listen), "listen addr must be [host]:port")
+	v.check(&c.HostName, validBaseURL(c.HostName), "base url must be http(s)://host[:port][/path]")
+	v.check(&c.LenShortURL, c.LenShortURL >= 1 && c.LenShortURL <= MaxLenShortURL, "length of short address must be in 1..%d", MaxLenShortURL)
+	switch c.ShortGen {
+	case GenRandom, GenCounter, GenHash, GenSqids:
+	default:
+		v.check(&c.ShortGen, false, "unknown short id generator")
+	}
+	v.check(&c.ShortAlpha, validAlphabet(c.ShortAlpha), "alphabet must have at least 2 unique chars of [A-Za-z0-9_-]")
+	v.check(&c.ReapInterval, c.ReapInterval >= 0, "must not be negative")
+	v.check(&c.ClickBuffer, c.ClickBuffer > 0, "must be positive")
+	v.check(&c.ClickFlush, c.ClickFlush > 0, "must be positive")
+	v.check(&c.LazyLoad, !c.LazyLoad || c.PgConnString != "", "lazy mode needs postgres, set DATABASE_DSN or -d")
+	v.check(&c.CacheSize, c.CacheSize > 0, "must be positive")
+	v.check(&c.CacheNegTTL, c.CacheNegTTL >= 0, "must not be negative")
+	v.check(&c.CacheMetrics, c.CacheMetrics >= 0, "must not be negative")
+	v.check(&c.CompactSize, c.CompactSize >= 0, "must not be negative")
+	v.check(&c.CompactRatio, c.CompactRatio >= 1, "must be at least 1")
+	switch c.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		v.check(&c.Fsync, false, "unknown fsync mode")
+	}
+	v.check(&c.FsyncEvery, c.FsyncEvery > 0, "must be positive")
+	v.check(&c.WALSegment, c.WALSegment >= 0, "must not be negative")
+	v.check(&c.DeleteWorkers, c.DeleteWorkers > 0, "must be positive")
+	v.check(&c.DeleteRetries, c.DeleteRetries > 0, "must be positive")
+	v.check(&c.DeleteTimeout, c.DeleteTimeout > 0, "must be positive")
+	v.check(&c.JWTTTL, c.JWTTTL > 0, "must be positive")
+	if c.TrustedSubnet != "" {
+		_, _, err := net.ParseCIDR(c.TrustedSubnet)
+		v.check(&c.TrustedSubnet, err == nil, "trusted subnet must be CIDR")
+	}
+	_, err := ParseRateLimits(c.RateLimits)
+	v.checkErr(&c.RateLimits, err)
+	v.check(&c.BlockedHosts, !strings.ContainsAny(c.BlockedHosts, "/: "), "blocked hosts must be comma separated host names")
+	v.check(&c.ShutdownTime, c.ShutdownTime > 0, "must be positive")
+	switch {
+	case c.TLSCertFile != "" && c.TLSKeyFile == "":
+		v.check(&c.TLSCertFile, false, "TLS certificate and key must be given together")
+	case c.TLSCertFile == "" && c.TLSKeyFile != "":
+		v.check(&c.TLSKeyFile, false, "TLS certificate and key must be given together")
+	}
+	if !c.EnableHTTPS {
+		v.check(&c.TLSCertFile, c.TLSCertFile == "", "TLS certificate needs HTTPS, set ENABLE_HTTPS or -s")
+		v.check(&c.HTTPRedirect, c.HTTPRedirect == "", "HTTP redirect needs HTTPS, set ENABLE_HTTPS or -s")
+	}
+	if c.HTTPRedirect != "" {
+		v.check(&c.HTTPRedirect, validListen(c.HTTPRedirect), "listen addr must be [host]:port")
+	}
+	if c.GRPCListen != "" {
+		v.check(&c.GRPCListen, validListen(c.GRPCListen), "listen addr must be [host]:port")
+	}
+	return errors.Join(v.errs...)
+}
+
+func validListen(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	_, err = strconv.ParseUint(port, 10, 16)
+	return err == nil
+}
+
+// Short ids are path segments and aliases share their keyspace, so
+// alphabet is limited to chars of alias
+func validAlphabet(alphabet string) bool {
+	seen := make(map[rune]bool, len(alphabet))
+	for _, ch := range alphabet {
+		valid := ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_' || ch == '-'
+		if !valid || seen[ch] {
+			return false
+		}
+		seen[ch] = true
+	}
+	return len(seen) >= 2
+}
+
+func validBaseURL(hostName string) bool {
+	u, err := url.Parse(hostName)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.User == nil && u.RawQuery == "" && u.Fragment == ""
+}
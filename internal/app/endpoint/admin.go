@@ -0,0 +1,158 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/go-chi/chi/v5"
+)
+
+// Admin endpoints, all of them are behind AdminOnly. Search takes
+// q (part of url or short id), user, deleted, limit and offset
+// query parameters.
+
+type adminServicer interface {
+	IsAdmin(ctx context.Context, userID string) (bool, error)
+	AdminSearch(ctx context.Context, filter model.URLFilter) ([]model.AdminURL, error)
+	AdminDelete(ctx context.Context, short string) (model.AdminURL, error)
+	AdminRestore(ctx context.Context, short string) (model.AdminURL, error)
+	AdminReassign(ctx context.Context, short, userID string) (model.AdminURL, error)
+	AuditLog(ctx context.Context, limit int) ([]model.AuditEntry, error)
+}
+
+// Reads config again and applies its runtime part
+type reloader interface {
+	Reload() (config.Runtime, error)
+}
+
+func (e *Endpoint) AdminOnly(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(config.ContextKeyUserID).(string)
+		admin, err := e.s.IsAdmin(r.Context(), userID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !admin {
+			log.Printf("User %s is refused admin access to %s", userID, r.URL.Path)
+			http.Error(w, fmt.Sprintf(" Error: %v", config.ErrNotAdmin), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (e *Endpoint) AdminSearch(w http.ResponseWriter, r *http.Request) {
+	filter, err := searchFilter(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
+		return
+	}
+	urls, err := e.s.AdminSearch(r.Context(), filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, urls)
+}
+
+func (e *Endpoint) AdminDelete(w http.ResponseWriter, r *http.Request) {
+	rec, err := e.s.AdminDelete(r.Context(), chi.URLParam(r, "id"))
+	writeAdminResult(w, rec, err)
+}
+
+func (e *Endpoint) AdminRestore(w http.ResponseWriter, r *http.Request) {
+	rec, err := e.s.AdminRestore(r.Context(), chi.URLParam(r, "id"))
+	writeAdminResult(w, rec, err)
+}
+
+// Takes {"user_id": ...} of new owner
+func (e *Endpoint) AdminReassign(w http.ResponseWriter, r *http.Request) {
+	bodyStr, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req := map[string]string{}
+	if err := json.Unmarshal(bodyStr, &req); err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
+		return
+	}
+	rec, err := e.s.AdminReassign(r.Context(), chi.URLParam(r, "id"), req[config.UserIDTag])
+	writeAdminResult(w, rec, err)
+}
+
+func (e *Endpoint) ShowAuditLog(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	entries, err := e.s.AuditLog(r.Context(), limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// Replies with applied runtime config, invalid config is left unapplied
+func (e *Endpoint) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Admin %s reloads config", r.Context().Value(config.ContextKeyUserID).(string))
+	rt, err := e.rl.Reload()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusOK, rt)
+}
+
+func searchFilter(r *http.Request) (model.URLFilter, error) {
+	query := r.URL.Query()
+	filter := model.URLFilter{
+		Query:  query.Get("q"),
+		UserID: query.Get("user"),
+	}
+	if value := query.Get("deleted"); value != "" {
+		deleted, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, config.ErrFilterNotValid
+		}
+		filter.Deleted = &deleted
+	}
+	for _, param := range []struct {
+		name  string
+		field *int
+	}{{"limit", &filter.Limit}, {"offset", &filter.Offset}} {
+		if value := query.Get(param.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return filter, config.ErrFilterNotValid
+			}
+			*param.field = n
+		}
+	}
+	return filter, nil
+}
+
+func writeAdminResult(w http.ResponseWriter, rec model.AdminURL, err error) {
+	if err != nil {
+		switch {
+		default:
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		case errors.Is(err, config.ErrNoSuchRecord):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusNotFound)
+		case errors.Is(err, config.ErrDuplicateURL):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusConflict)
+		case errors.Is(err, config.ErrInvalidReqBody), errors.Is(err, config.ErrUserIDNotValid):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
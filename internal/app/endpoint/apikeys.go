@@ -0,0 +1,112 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/go-chi/chi/v5"
+)
+
+// API keys of current user. Create and rename take {"name": ...},
+// key itself is in response of create only.
+
+type apiKeyServicer interface {
+	CreateAPIKey(ctx context.Context, userID, name string) (model.APIKeyInfo, error)
+	GetAPIKeys(ctx context.Context, userID string) ([]model.APIKeyInfo, error)
+	RenameAPIKey(ctx context.Context, userID, id, name string) (model.APIKeyInfo, error)
+	RevokeAPIKey(ctx context.Context, userID, id string) error
+}
+
+func (e *Endpoint) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	name, ok := keyName(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value(config.ContextKeyUserID).(string)
+	key, err := e.s.CreateAPIKey(r.Context(), userID, name)
+	if err != nil {
+		apiKeyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, key)
+}
+
+func (e *Endpoint) ShowAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.ContextKeyUserID).(string)
+	keys, err := e.s.GetAPIKeys(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (e *Endpoint) RenameAPIKey(w http.ResponseWriter, r *http.Request) {
+	name, ok := keyName(w, r)
+	if !ok {
+		return
+	}
+	userID := r.Context().Value(config.ContextKeyUserID).(string)
+	key, err := e.s.RenameAPIKey(r.Context(), userID, chi.URLParam(r, "id"), name)
+	if err != nil {
+		apiKeyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+func (e *Endpoint) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.ContextKeyUserID).(string)
+	if err := e.s.RevokeAPIKey(r.Context(), userID, chi.URLParam(r, "id")); err != nil {
+		apiKeyError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Reads key name from request, on error writes response
+func keyName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	bodyStr, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return "", false
+	}
+	req := map[string]string{}
+	if err := json.Unmarshal(bodyStr, &req); err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
+		return "", false
+	}
+	return req[config.KeyNameTag], true
+}
+
+func apiKeyError(w http.ResponseWriter, err error) {
+	switch {
+	default:
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+	case errors.Is(err, config.ErrKeyNameNotValid):
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
+	case errors.Is(err, config.ErrNoSuchRecord):
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, res any) {
+	buf, err := json.MarshalIndent(res, "", "   ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf)
+}
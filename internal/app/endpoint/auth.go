@@ -0,0 +1,101 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+)
+
+// Account endpoints. Request body is {"email": ..., "password": ...},
+// on success client gets cookie (and token) of account user id.
+// Requests must be application/json, as form of another site can't
+// post it without CORS preflight.
+
+type authServicer interface {
+	Register(ctx context.Context, email, password string) (string, int, error)
+	Login(ctx context.Context, email, password string) (string, int, error)
+}
+
+// Gives user id to client and takes it back
+type identifier interface {
+	SetUser(w http.ResponseWriter, userID string) error
+	ClearUser(w http.ResponseWriter)
+}
+
+type authResponse struct {
+	UserID    string `json:"user_id"`
+	MovedURLs int    `json:"moved_urls"`
+}
+
+func (e *Endpoint) Register(w http.ResponseWriter, r *http.Request) {
+	e.auth(w, r, e.s.Register, http.StatusCreated)
+}
+
+func (e *Endpoint) Login(w http.ResponseWriter, r *http.Request) {
+	e.auth(w, r, e.s.Login, http.StatusOK)
+}
+
+func (e *Endpoint) Logout(w http.ResponseWriter, r *http.Request) {
+	if !isJSON(r) {
+		http.Error(w, fmt.Sprintf(" Error: %v", config.ErrNotJSON), http.StatusUnsupportedMediaType)
+		return
+	}
+	e.id.ClearUser(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *Endpoint) auth(w http.ResponseWriter, r *http.Request,
+	fn func(ctx context.Context, email, password string) (string, int, error), okStatus int) {
+	if !isJSON(r) {
+		http.Error(w, fmt.Sprintf(" Error: %v", config.ErrNotJSON), http.StatusUnsupportedMediaType)
+		return
+	}
+	bodyStr, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req := map[string]string{}
+	if err := json.Unmarshal(bodyStr, &req); err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	userID, moved, err := fn(r.Context(), req[config.EmailTag], req[config.PasswordTag])
+	if err != nil {
+		switch {
+		default:
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		case errors.Is(err, config.ErrAccountNotValid):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
+		case errors.Is(err, config.ErrEmailTaken):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusConflict)
+		case errors.Is(err, config.ErrBadCredentials):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusUnauthorized)
+		}
+		return
+	}
+	if err := e.id.SetUser(w, userID); err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	buf, err := json.Marshal(authResponse{UserID: userID, MovedURLs: moved})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(okStatus)
+	w.Write(buf)
+}
+
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
@@ -1,46 +1,63 @@
 package endpoint
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
 	"github.com/go-chi/chi/v5"
 )
 
 type Endpoint struct {
-	s servicer
-	c *config.Config
+	s  servicer
+	c  *config.Config
+	id identifier
+	rl reloader
 }
 
 type servicer interface {
-	Post(ctx context.Context, URL string) (string, error)
+	Post(ctx context.Context, URL string, opts model.ShortOpts) (string, error)
 	PostBatch(ctx context.Context, URLs []map[string]string) ([]map[string]string, error)
-	Get(ID string) (string, error)
-	GetURLByUser(userID string) []map[string]string
-	DeleteURLs(ctx context.Context, shorts []string) error
+	Get(ctx context.Context, ID string) (string, error)
+	GetURLByUser(ctx context.Context, userID string) ([]map[string]string, error)
+	DeleteURLs(ctx context.Context, shorts []string) (string, error)
+	GetDeleteJob(ctx context.Context, userID, id string) (model.DeleteJobStatus, error)
+	RecordClick(click model.Click)
+	GetStats(ctx context.Context, userID, short, bucket string) (model.ClickStats, error)
 	PingDB(ctx context.Context) error
-	GetLen() int
+	GetLen(ctx context.Context) (int, error)
+	Metrics() map[string]int64
+	GetInternalStats(ctx context.Context) (model.InternalStats, error)
+	authServicer
+	apiKeyServicer
+	adminServicer
 }
 
-func New(s servicer, c *config.Config) *Endpoint {
+func New(s servicer, c *config.Config, id identifier, rl reloader) *Endpoint {
 	e := &Endpoint{}
 	e.s = s
 	e.c = c
+	e.id = id
+	e.rl = rl
 	return e
 }
 
 func (e *Endpoint) Get(w http.ResponseWriter, r *http.Request) {
 	urlID := chi.URLParam(r, "id")
-	longURL, err := e.s.Get(urlID)
+	longURL, err := e.s.Get(r.Context(), urlID)
 	if err != nil {
 		switch {
-		case errors.Is(err, config.ErrURLDeleted):
+		case errors.Is(err, config.ErrURLDeleted), errors.Is(err, config.ErrURLExpired):
 			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusGone)
 		default:
 			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
@@ -50,6 +67,19 @@ func (e *Endpoint) Get(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Location", longURL)
 	w.WriteHeader(http.StatusTemporaryRedirect)
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// middleware.RealIP puts address without port
+		ip = r.RemoteAddr
+	}
+	e.s.RecordClick(model.Click{
+		Short:     urlID,
+		Time:      time.Now(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        ip,
+	})
 }
 
 func (e *Endpoint) Post(w http.ResponseWriter, r *http.Request) {
@@ -59,7 +89,7 @@ func (e *Endpoint) Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	retStatus := http.StatusCreated
-	shortURL, err := e.s.Post(r.Context(), string(bodyStr))
+	shortURL, err := e.s.Post(r.Context(), string(bodyStr), model.ShortOpts{})
 	if err != nil {
 		switch {
 		default:
@@ -75,6 +105,48 @@ func (e *Endpoint) Post(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(shortURL))
 }
 
+// Request of PostAPI, batch entries are the same with correlation id
+type shortenRequest struct {
+	URL           string `json:"url"`
+	OriginalURL   string `json:"original_url"`
+	CorrelationID string `json:"correlation_id"`
+	Alias         string `json:"alias"`
+	TTL           ttl    `json:"ttl"`
+	ExpiresAt     string `json:"expires_at"`
+}
+
+func (req shortenRequest) opts() model.ShortOpts {
+	return model.ShortOpts{
+		Alias:     req.Alias,
+		TTL:       string(req.TTL),
+		ExpiresAt: req.ExpiresAt,
+	}
+}
+
+// ttl is given either as number of seconds or as duration string, like
+// 3600 or "1h". It is parsed by service in both cases.
+type ttl string
+
+func (t *ttl) UnmarshalJSON(data []byte) error {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case nil:
+		*t = ""
+	case string:
+		*t = ttl(value)
+	case json.Number:
+		*t = ttl(value.String())
+	default:
+		return config.ErrExpiryNotValid
+	}
+	return nil
+}
+
 func (e *Endpoint) PostAPI(w http.ResponseWriter, r *http.Request) {
 	bodyStr, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -83,7 +155,7 @@ func (e *Endpoint) PostAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req := map[string]string{}
+	req := shortenRequest{}
 	err = json.Unmarshal(bodyStr, &req)
 	if err != nil {
 		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
@@ -91,12 +163,15 @@ func (e *Endpoint) PostAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	retStatus := http.StatusCreated
-	shortURL, err := e.s.Post(r.Context(), req[config.PostAPIreqTag])
+	shortURL, err := e.s.Post(r.Context(), req.URL, req.opts())
 	if err != nil {
 		switch {
 		default:
 			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
 			return
+		case errors.Is(err, config.ErrAliasTaken), errors.Is(err, config.ErrURLHasShort):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusConflict)
+			return
 		case errors.Is(err, config.ErrDuplicateURL):
 			retStatus = http.StatusConflict
 		}
@@ -120,16 +195,35 @@ func (e *Endpoint) PostBatchAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req := make([]map[string]string, 0)
+	req := make([]shortenRequest, 0)
 	err = json.Unmarshal(bodyStr, &req)
 	if err != nil {
 		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
 		return
 	}
+	urls := make([]map[string]string, 0, len(req))
+	for _, url := range req {
+		opts := url.opts()
+		urls = append(urls, map[string]string{
+			"correlation_id":  url.CorrelationID,
+			"original_url":    url.OriginalURL,
+			config.AliasTag:   opts.Alias,
+			config.TTLTag:     opts.TTL,
+			config.ExpiresTag: opts.ExpiresAt,
+		})
+	}
 
-	res, err := e.s.PostBatch(r.Context(), req)
+	res, err := e.s.PostBatch(r.Context(), urls)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		switch {
+		default:
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		case errors.Is(err, config.ErrAliasTaken), errors.Is(err, config.ErrURLHasShort):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusConflict)
+		case errors.Is(err, config.ErrAliasNotValid), errors.Is(err, config.ErrAliasReserved),
+			errors.Is(err, config.ErrExpiryNotValid), errors.Is(err, config.ErrURLBlocked):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
+		}
 		return
 	}
 	buf, err := json.MarshalIndent(res, "", " ")
@@ -156,18 +250,52 @@ func (e *Endpoint) DeleteBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = e.s.DeleteURLs(r.Context(), req)
+	jobID, err := e.s.DeleteURLs(r.Context(), req)
 	if err != nil {
 		http.Error(w, fmt.Sprintf(" Error(s): %v", err), http.StatusInternalServerError)
 		return
 	}
+	buf, err := json.Marshal(map[string]string{"job_id": jobID})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusAccepted)
+	w.Write(buf)
+}
+
+func (e *Endpoint) ShowDeleteJob(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.ContextKeyUserID).(string)
+	job, err := e.s.GetDeleteJob(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		switch {
+		default:
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		case errors.Is(err, config.ErrNoSuchRecord):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusNotFound)
+		case errors.Is(err, config.ErrNotOwner):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusForbidden)
+		}
+		return
+	}
+	buf, err := json.MarshalIndent(job, "", "   ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
 }
 
 func (e *Endpoint) ShowURLByUser(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(config.ContextKeyUserID).(string)
-	urlByUser := e.s.GetURLByUser(userID)
+	urlByUser, err := e.s.GetURLByUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
 	if len(urlByUser) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
@@ -183,6 +311,33 @@ func (e *Endpoint) ShowURLByUser(w http.ResponseWriter, r *http.Request) {
 	w.Write(buf)
 }
 
+func (e *Endpoint) ShowStats(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(config.ContextKeyUserID).(string)
+	urlID := chi.URLParam(r, "id")
+	stats, err := e.s.GetStats(r.Context(), userID, urlID, r.URL.Query().Get("bucket"))
+	if err != nil {
+		switch {
+		default:
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		case errors.Is(err, config.ErrNoSuchRecord):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusNotFound)
+		case errors.Is(err, config.ErrNotOwner):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusForbidden)
+		case errors.Is(err, config.ErrBucketNotValid):
+			http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusBadRequest)
+		}
+		return
+	}
+	buf, err := json.MarshalIndent(stats, "", "   ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
+
 func (e *Endpoint) Ping(w http.ResponseWriter, r *http.Request) {
 	err := e.s.PingDB(r.Context())
 	if err != nil {
@@ -193,7 +348,31 @@ func (e *Endpoint) Ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("PONG"))
 }
 
+// Counts for monitoring, route is behind trusted subnet check
+func (e *Endpoint) InternalStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := e.s.GetInternalStats(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
 func (e *Endpoint) Info(w http.ResponseWriter, r *http.Request) {
+	stored, err := e.s.GetLen(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf(" %d record(s) stored", e.s.GetLen())))
+	w.Write([]byte(fmt.Sprintf(" %d record(s) stored", stored)))
+	metrics := e.s.Metrics()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.Write([]byte(fmt.Sprintf("\n %s: %d", name, metrics[name])))
+	}
 }
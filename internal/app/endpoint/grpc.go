@@ -0,0 +1,156 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/mware"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/pb"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC server of the same servicer as HTTP endpoints. User id is put to
+// context by mware.UserID interceptor, Stats is guarded by
+// mware.TrustedSubnet one.
+
+type GRPC struct {
+	pb.UnimplementedShortenerServer
+	s servicer
+}
+
+func NewGRPC(s servicer) *GRPC {
+	return &GRPC{s: s}
+}
+
+// Already shortened url is not an error, its old short url is returned
+func (g *GRPC) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	shortURL, err := g.s.Post(ctx, req.Url, model.ShortOpts{
+		Alias:     req.Alias,
+		TTL:       req.Ttl,
+		ExpiresAt: req.ExpiresAt,
+	})
+	switch {
+	case errors.Is(err, config.ErrDuplicateURL):
+		return &pb.ShortenResponse{ShortUrl: shortURL, Existed: true}, nil
+	case err != nil:
+		return nil, grpcError(err)
+	}
+	return &pb.ShortenResponse{ShortUrl: shortURL}, nil
+}
+
+func (g *GRPC) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	urls := make([]map[string]string, 0, len(req.Urls))
+	for _, url := range req.Urls {
+		urls = append(urls, map[string]string{
+			"correlation_id":  url.CorrelationId,
+			"original_url":    url.OriginalUrl,
+			config.AliasTag:   url.Alias,
+			config.TTLTag:     url.Ttl,
+			config.ExpiresTag: url.ExpiresAt,
+		})
+	}
+	created, err := g.s.PostBatch(ctx, urls)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &pb.ShortenBatchResponse{Urls: make([]*pb.ShortenBatchResponse_URL, 0, len(created))}
+	for _, rec := range created {
+		res.Urls = append(res.Urls, &pb.ShortenBatchResponse_URL{
+			CorrelationId: rec["correlation_id"],
+			ShortUrl:      rec["short_url"],
+		})
+	}
+	return res, nil
+}
+
+// Counts as redirect in click stats
+func (g *GRPC) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	longURL, err := g.s.Get(ctx, req.ShortId)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	userAgent := ""
+	if values := md.Get("user-agent"); len(values) > 0 {
+		userAgent = values[0]
+	}
+	g.s.RecordClick(model.Click{
+		Short:     req.ShortId,
+		Time:      time.Now(),
+		UserAgent: userAgent,
+		IP:        mware.GRPCClientIP(ctx),
+	})
+	return &pb.ResolveResponse{OriginalUrl: longURL}, nil
+}
+
+func (g *GRPC) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	userID := ctx.Value(config.ContextKeyUserID).(string)
+	urls, err := g.s.GetURLByUser(ctx, userID)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	res := &pb.ListUserURLsResponse{Urls: make([]*pb.ListUserURLsResponse_URL, 0, len(urls))}
+	for _, rec := range urls {
+		res.Urls = append(res.Urls, &pb.ListUserURLsResponse_URL{
+			ShortUrl:    rec["short_url"],
+			OriginalUrl: rec["original_url"],
+			ExpiresAt:   rec[config.ExpiresTag],
+		})
+	}
+	return res, nil
+}
+
+// Queues deletion job, as HTTP endpoint does
+func (g *GRPC) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	jobID, err := g.s.DeleteURLs(ctx, req.ShortUrls)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.DeleteUserURLsResponse{JobId: jobID}, nil
+}
+
+func (g *GRPC) Ping(ctx context.Context, req *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := g.s.PingDB(ctx); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &pb.PingResponse{}, nil
+}
+
+func (g *GRPC) Stats(ctx context.Context, req *pb.StatsRequest) (*pb.StatsResponse, error) {
+	stats, err := g.s.GetInternalStats(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.StatsResponse{
+		Urls:        int64(stats.URLs),
+		Users:       int64(stats.Users),
+		DeletedUrls: int64(stats.Deleted),
+		Clicks:      int64(stats.Clicks),
+	}, nil
+}
+
+// Status of service error, as close to HTTP status as gRPC codes go
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, config.ErrNoSuchRecord), errors.Is(err, config.ErrURLDeleted),
+		errors.Is(err, config.ErrURLExpired):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, config.ErrAliasTaken), errors.Is(err, config.ErrURLHasShort):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, config.ErrURLNotCorrect), errors.Is(err, config.ErrEmptyReqBody),
+		errors.Is(err, config.ErrAliasNotValid), errors.Is(err, config.ErrAliasReserved),
+		errors.Is(err, config.ErrExpiryNotValid), errors.Is(err, config.ErrURLBlocked):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, config.ErrNotOwner):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	log.Printf(" Error: %v", err)
+	return status.Error(codes.Internal, err.Error())
+}
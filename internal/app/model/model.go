@@ -1,8 +1,165 @@
 package model
 
+import "time"
+
 type ShortURL struct {
-	Short   string `json:"SHORT"`
-	URL     string `json:"URL"`
-	UserID  string `json:"USERID"`
-	Deleted bool   `json:"DELETED"`
+	Short     string     `json:"SHORT"`
+	URL       string     `json:"URL"`
+	UserID    string     `json:"USERID"`
+	Deleted   bool       `json:"DELETED"`
+	ExpiresAt *time.Time `json:"EXPIRES,omitempty"`
+}
+
+// Optional parameters of short url creation,
+// TTL and ExpiresAt are as given in request.
+type ShortOpts struct {
+	Alias     string
+	TTL       string
+	ExpiresAt string
+}
+
+// One redirect by short url
+type Click struct {
+	Short     string    `json:"SHORT"`
+	Time      time.Time `json:"TIME"`
+	Referrer  string    `json:"REFERRER"`
+	UserAgent string    `json:"USERAGENT"`
+	IP        string    `json:"IP"`
+}
+
+// Redirects count by short url, total and by time buckets
+type ClickStats struct {
+	ShortURL string        `json:"short_url"`
+	Bucket   string        `json:"bucket"`
+	Total    int           `json:"total"`
+	Buckets  []ClickBucket `json:"buckets"`
+}
+
+type ClickBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// Results of deletion of one short url
+const (
+	DeleteDone     = "deleted"
+	DeleteAlready  = "already_deleted"
+	DeleteNotOwner = "not_owner"
+	DeleteNotFound = "not_found"
+)
+
+// Request of user to delete short urls. It is kept in repository until
+// done, Results has result for every short id processed so far.
+type DeleteJob struct {
+	ID        string            `json:"ID"`
+	UserID    string            `json:"USERID"`
+	Shorts    []string          `json:"SHORTS"`
+	Results   map[string]string `json:"RESULTS"`
+	Attempts  int               `json:"ATTEMPTS"`
+	LastError string            `json:"LASTERROR,omitempty"`
+	Done      bool              `json:"DONE"`
+	Created   time.Time         `json:"CREATED"`
+}
+
+// States of deletion job
+const (
+	JobQueued   = "queued"
+	JobRetrying = "retrying"
+	JobDone     = "done"
+	JobFailed   = "failed"
+)
+
+// Deletion job as shown to user
+type DeleteJobStatus struct {
+	JobID     string         `json:"job_id"`
+	Status    string         `json:"status"`
+	Total     int            `json:"total"`
+	Processed int            `json:"processed"`
+	Error     string         `json:"error,omitempty"`
+	Results   []DeleteResult `json:"results"`
+}
+
+// Result is empty while short url is not processed
+type DeleteResult struct {
+	ShortURL string `json:"short_url"`
+	Result   string `json:"result,omitempty"`
+}
+
+// Registered user. ID is user id of links owned by account.
+type Account struct {
+	ID           string    `json:"ID"`
+	Email        string    `json:"EMAIL"`
+	PasswordHash string    `json:"PASSWORD"`
+	Created      time.Time `json:"CREATED"`
+}
+
+// API key of user. Only hash of key is kept, Prefix is kept
+// to tell keys apart.
+type APIKey struct {
+	ID       string     `json:"ID"`
+	UserID   string     `json:"USERID"`
+	Name     string     `json:"NAME"`
+	Prefix   string     `json:"PREFIX"`
+	Hash     string     `json:"HASH"`
+	Created  time.Time  `json:"CREATED"`
+	LastUsed *time.Time `json:"LASTUSED,omitempty"`
+	Revoked  *time.Time `json:"REVOKED,omitempty"`
+}
+
+// API key as shown to user, Key itself is shown once on creation
+type APIKeyInfo struct {
+	ID       string     `json:"id"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Key      string     `json:"key,omitempty"`
+	Created  time.Time  `json:"created_at"`
+	LastUsed *time.Time `json:"last_used_at,omitempty"`
+	Revoked  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Filter of admin search. Query is part of url or exact short id,
+// empty fields match any record.
+type URLFilter struct {
+	Query   string
+	UserID  string
+	Deleted *bool
+	Limit   int
+	Offset  int
+}
+
+// Short url record as shown to admin
+type AdminURL struct {
+	ID        string     `json:"id"`
+	ShortURL  string     `json:"short_url"`
+	URL       string     `json:"original_url"`
+	UserID    string     `json:"user_id"`
+	Deleted   bool       `json:"deleted"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Admin actions
+const (
+	AuditDelete      = "delete"
+	AuditRestore     = "restore"
+	AuditReassign    = "reassign"
+	AuditGrantAdmin  = "grant_admin"
+	AuditRevokeAdmin = "revoke_admin"
+)
+
+// One admin action. Admin is user id of admin, or "cli" for command line.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Admin   string    `json:"admin"`
+	Action  string    `json:"action"`
+	Short   string    `json:"short,omitempty"`
+	UserID  string    `json:"user_id,omitempty"`
+	Details string    `json:"details,omitempty"`
+}
+
+// Counts for internal monitoring. Users are owners of links.
+type InternalStats struct {
+	URLs    int `json:"urls"`
+	Users   int `json:"users"`
+	Deleted int `json:"deleted_urls"`
+	Clicks  int `json:"clicks"`
 }
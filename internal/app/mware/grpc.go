@@ -0,0 +1,147 @@
+package mware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Interceptors of gRPC calls doing what middleware does for HTTP.
+// Metadata keys are header names in lower case: x-api-key, authorization,
+// and user-id instead of cookie. Client address is address of peer.
+
+// Puts user id to context as Handler does. New or reissued sealed user
+// id goes to response header as user-id, with token if tokens are issued.
+func (u *UserID) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if key := firstMeta(md, config.APIKeyHeader); key != "" && u.apiKeys != nil {
+		userID, err := u.apiKeys.UserByAPIKey(ctx, key)
+		switch {
+		case errors.Is(err, config.ErrAPIKeyNotValid):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		case err != nil:
+			log.Printf("Error in api key check: %v\n", err)
+			return nil, status.Error(codes.Internal, "api key check failed")
+		}
+		return handler(context.WithValue(ctx, config.ContextKeyUserID, userID), req)
+	}
+	if token, ok := parseBearer(firstMeta(md, config.TokenHeader)); ok && u.tokens != nil {
+		userID, err := u.tokens.parse(token)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return handler(context.WithValue(ctx, config.ContextKeyUserID, userID), req)
+	}
+
+	var userID string
+	reissue := true
+	if sealed := firstMeta(md, config.UserIDMeta); sealed != "" {
+		var keyID string
+		var err error
+		userID, keyID, err = u.open(sealed)
+		if err != nil {
+			log.Printf("Error in user id, new user id is issued: %v\n", err)
+		}
+		reissue = err != nil || keyID != u.keys[0].id
+	}
+	header := metadata.MD{}
+	if userID == "" {
+		var err error
+		userID, err = getNewUserID(userIDByteSize)
+		if err != nil {
+			log.Printf("Error in rand: %v\n", err)
+			return nil, status.Error(codes.Internal, "user id is not issued")
+		}
+		if u.tokens.canIssue() {
+			token, err := u.tokens.issue(userID)
+			if err != nil {
+				log.Printf("Error in encode: %v\n", err)
+				return nil, status.Error(codes.Internal, "user id is not issued")
+			}
+			header.Set(config.TokenHeader, "Bearer "+token)
+		}
+	}
+	if reissue {
+		sealed, err := u.seal(userID)
+		if err != nil {
+			log.Printf("Error in encode: %v\n", err)
+			return nil, status.Error(codes.Internal, "user id is not issued")
+		}
+		header.Set(config.UserIDMeta, sealed)
+	}
+	if len(header) > 0 {
+		if err := grpc.SetHeader(ctx, header); err != nil {
+			log.Printf("Error in response header: %v\n", err)
+		}
+	}
+
+	log.Printf("User id: %s", userID)
+	return handler(context.WithValue(ctx, config.ContextKeyUserID, userID), req)
+}
+
+// Lets calls of given methods through from trusted subnet only
+func (ts *TrustedSubnet) UnaryInterceptor(methods ...string) grpc.UnaryServerInterceptor {
+	guarded := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		guarded[method] = true
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if guarded[info.FullMethod] {
+			if addr := GRPCClientIP(ctx); !ts.contains(addr) {
+				log.Printf("Call %s from %s is refused: %v", info.FullMethod, addr, ErrNotTrusted)
+				return nil, status.Error(codes.PermissionDenied, ErrNotTrusted.Error())
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Limits calls with limiter of their method, the same limiters as of
+// HTTP route groups. Methods without limiter are not limited.
+func RateLimitInterceptor(limiters map[string]*RateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		rl, found := limiters[info.FullMethod]
+		if !found {
+			return handler(ctx, req)
+		}
+		keys := bucketKeys(ctx, GRPCClientIP(ctx))
+		limit, _, wait, _, ok := rl.take(keys)
+		if !ok && !limit.IsZero() {
+			retryAfter := strconv.Itoa(ceilSeconds(wait))
+			log.Printf("Call %s from %v is rate limited", info.FullMethod, keys)
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter))
+			return nil, status.Errorf(codes.ResourceExhausted, "%v, retry after %s s", ErrRateLimited, retryAfter)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Returns ip of peer. gRPC port is not behind proxy, so x-real-ip and
+// x-forwarded-for sent by client are not trusted.
+func GRPCClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+func firstMeta(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
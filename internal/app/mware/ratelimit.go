@@ -0,0 +1,202 @@
+package mware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+)
+
+// Token bucket rate limiter. Every route group has its own limiter with
+// buckets by user id and by client ip. Request takes token from both
+// buckets, so new cookie does not give new limit. Idle buckets get full
+// and are dropped from time to time.
+//
+// Client ip is the one of TCP peer saved by PeerAddr before
+// middleware.RealIP, X-Real-IP and X-Forwarded-For are set by client
+// and would give new ip bucket on every request. Only peer from trusted
+// subnet is proxy, its requests are limited by ip set by RealIP.
+//
+// Responses carry RateLimit-Limit (burst), RateLimit-Remaining and
+// RateLimit-Reset (seconds until bucket is full), refused ones
+// carry Retry-After too.
+//
+// Limit may be changed on the fly by SetLimit, buckets are kept and
+// get refilled at new rate up to new burst.
+
+const rateSweepEvery = time.Minute
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type RateLimiter struct {
+	mu        sync.Mutex
+	limit     config.RateLimit
+	buckets   map[string]*bucket // by "user:<id>" and "ip:<addr>"
+	lastSweep time.Time
+	now       func() time.Time
+	proxies   *TrustedSubnet // nil if nobody is trusted
+}
+
+func NewRateLimiter(limit config.RateLimit, proxies *TrustedSubnet) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+		proxies: proxies,
+	}
+}
+
+type rateLimitedResponse struct {
+	Error      string `json:"error"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// Zero limit turns limiter off and drops its buckets
+func (rl *RateLimiter) SetLimit(limit config.RateLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.limit = limit
+	if limit.IsZero() {
+		rl.buckets = make(map[string]*bucket)
+	}
+}
+
+func (rl *RateLimiter) Handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		keys := bucketKeys(r.Context(), rl.clientIP(r))
+		limit, remaining, wait, reset, ok := rl.take(keys)
+		if limit.IsZero() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := ceilSeconds(wait)
+		log.Printf("Request %s %s from %v is rate limited", r.Method, r.URL.Path, keys)
+		buf, err := json.Marshal(rateLimitedResponse{Error: ErrRateLimited.Error(), RetryAfter: retryAfter})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write(buf)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Keeps RemoteAddr of TCP peer in context, must go before
+// middleware.RealIP which replaces it
+func PeerAddr(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), config.ContextKeyPeerAddr, r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+func peerAddr(r *http.Request) string {
+	if addr, ok := r.Context().Value(config.ContextKeyPeerAddr).(string); ok {
+		return addr
+	}
+	return r.RemoteAddr
+}
+
+func (rl *RateLimiter) clientIP(r *http.Request) string {
+	peer := peerAddr(r)
+	if rl.proxies != nil && rl.proxies.contains(peer) {
+		return clientIP(r.RemoteAddr)
+	}
+	return clientIP(peer)
+}
+
+// Buckets of request: by user id, if it is known, and by client ip
+func bucketKeys(ctx context.Context, ip string) []string {
+	keys := make([]string, 0, 2)
+	if userID, ok := ctx.Value(config.ContextKeyUserID).(string); ok && userID != "" {
+		keys = append(keys, "user:"+userID)
+	}
+	return append(keys, "ip:"+ip)
+}
+
+// Takes token from every bucket of keys, if all of them have one.
+// Returns limit in force, tokens left in the emptiest bucket, time to
+// wait for next token and time until the emptiest bucket is full.
+func (rl *RateLimiter) take(keys []string) (config.RateLimit, int, time.Duration, time.Duration, bool) {
+	now := rl.now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.limit.IsZero() {
+		return rl.limit, 0, 0, 0, true
+	}
+	rl.sweep(now)
+
+	burst := float64(rl.limit.Burst)
+	least := burst
+	buckets := make([]*bucket, 0, len(keys))
+	for _, key := range keys {
+		b, ok := rl.buckets[key]
+		if !ok {
+			b = &bucket{tokens: burst, last: now}
+			rl.buckets[key] = b
+		}
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rl.limit.Rate)
+		b.last = now
+		least = math.Min(least, b.tokens)
+		buckets = append(buckets, b)
+	}
+
+	ok := least >= 1
+	wait := time.Duration(0)
+	if ok {
+		for _, b := range buckets {
+			b.tokens--
+		}
+		least--
+	} else {
+		wait = rl.refillTime(1 - least)
+	}
+	return rl.limit, int(least), wait, rl.refillTime(burst - least), ok
+}
+
+func (rl *RateLimiter) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens / rl.limit.Rate * float64(time.Second))
+}
+
+// Drops buckets which are full by now.
+// Must be called with rl.mu locked.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateSweepEvery {
+		return
+	}
+	rl.lastSweep = now
+	full := rl.refillTime(float64(rl.limit.Burst))
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) >= full {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,156 @@
+package mware
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/go-chi/chi/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limits, err := config.ParseRateLimits("create=60/m:2")
+	require.NoError(t, err)
+	for _, bad := range []string{"create=60", "create=0/m", "create=1/d", "create=1/m:0", "nosuch=1/m"} {
+		_, err := config.ParseRateLimits(bad)
+		assert.Error(t, err, bad)
+	}
+
+	rl := NewRateLimiter(limits[config.RateCreate], nil)
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+	handler := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func(userID, ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = ip + ":4000"
+		r = r.WithContext(context.WithValue(r.Context(), config.ContextKeyUserID, userID))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post("user1", "10.0.0.1")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, http.StatusCreated, post("user1", "10.0.0.1").Code)
+
+	w = post("user1", "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	res := rateLimitedResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, 1, res.RetryAfter)
+
+	// new user from the same ip and old user from another ip are limited too
+	assert.Equal(t, http.StatusTooManyRequests, post("user2", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, post("user1", "10.0.0.2").Code)
+	assert.Equal(t, http.StatusCreated, post("user2", "10.0.0.2").Code)
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusCreated, post("user1", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, post("user1", "10.0.0.1").Code)
+
+	// full buckets are dropped
+	now = now.Add(time.Hour)
+	assert.Equal(t, http.StatusCreated, post("user1", "10.0.0.1").Code)
+	assert.Len(t, rl.buckets, 2)
+}
+
+func TestRateLimiter_SetLimit(t *testing.T) {
+	rl := NewRateLimiter(config.RateLimit{}, nil)
+	handler := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// no limit at start, handler is limited after reload
+	w := post()
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+
+	rl.SetLimit(config.RateLimit{Rate: 1, Burst: 1, Period: time.Second})
+	w = post()
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, http.StatusTooManyRequests, post().Code)
+
+	rl.SetLimit(config.RateLimit{})
+	assert.Equal(t, http.StatusCreated, post().Code)
+	assert.Empty(t, rl.buckets)
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	limits, err := config.ParseRateLimits("create=60/m:1")
+	require.NoError(t, err)
+	rl := NewRateLimiter(limits[config.RateCreate], nil)
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+	interceptor := RateLimitInterceptor(map[string]*RateLimiter{"/test/Create": rl})
+	call := func(method, ip string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 4000}})
+		ctx = context.WithValue(ctx, config.ContextKeyUserID, "user1")
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+		return err
+	}
+
+	require.NoError(t, call("/test/Create", "10.0.0.1"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("/test/Create", "10.0.0.1")))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("/test/Create", "10.0.0.2")), "user is limited from any ip")
+	assert.NoError(t, call("/test/Other", "10.0.0.1"), "method without limiter")
+
+	// limit of reloaded config applies to calls too
+	rl.SetLimit(config.RateLimit{})
+	assert.NoError(t, call("/test/Create", "10.0.0.1"))
+}
+
+func TestRateLimiter_SpoofedIP(t *testing.T) {
+	limits, err := config.ParseRateLimits("create=60/m:1")
+	require.NoError(t, err)
+	rl := NewRateLimiter(limits[config.RateCreate], nil)
+	handler := PeerAddr(middleware.RealIP(rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))))
+	post := func(peer, realIP string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = peer + ":4000"
+		r.Header.Set("X-Real-IP", realIP)
+		r.Header.Set("X-Forwarded-For", realIP)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusCreated, post("10.0.0.1", "192.168.0.1"))
+	assert.Equal(t, http.StatusTooManyRequests, post("10.0.0.1", "192.168.0.2"), "new header ip gives no new bucket")
+	assert.Equal(t, http.StatusCreated, post("10.0.0.2", "192.168.0.1"))
+
+	// clients behind trusted proxy have buckets of their own
+	rl.proxies, err = NewTrustedSubnet("172.16.0.0/12")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, post("172.16.0.1", "192.168.0.1"))
+	assert.Equal(t, http.StatusCreated, post("172.16.0.1", "192.168.0.2"))
+	assert.Equal(t, http.StatusTooManyRequests, post("172.16.0.1", "192.168.0.2"))
+}
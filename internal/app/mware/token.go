@@ -0,0 +1,104 @@
+package mware
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+)
+
+// API clients may give user id as JWT in "Authorization: Bearer" header
+// instead of cookie. Token is signed by HS256 with JWT_SECRET or by RS256
+// with JWT_PRIVATE_KEY, if only JWT_PUBLIC_KEY is given tokens are checked
+// but not issued. User id is in "sub" claim, "iss" and "exp" are required.
+
+var ErrTokenNotValid = errors.New("token is not valid")
+
+type tokenKeys struct {
+	method    jwt.SigningMethod
+	signKey   any // nil if tokens are not issued
+	verifyKey any
+	issuer    string
+	ttl       time.Duration
+}
+
+// Returns nil if tokens are not configured
+func newTokenKeys(c *config.Config) (*tokenKeys, error) {
+	tk := &tokenKeys{
+		issuer: c.JWTIssuer,
+		ttl:    c.JWTTTL,
+	}
+	switch {
+	case c.JWTSecret != "" && (c.JWTPrivateKey != "" || c.JWTPublicKey != ""):
+		return nil, errors.New("either jwt secret or jwt rsa keys must be given, not both")
+	case c.JWTSecret != "":
+		if len(c.JWTSecret) < minSecretLen {
+			return nil, fmt.Errorf("jwt secret is shorter than %d chars", minSecretLen)
+		}
+		tk.method = jwt.SigningMethodHS256
+		tk.signKey, tk.verifyKey = []byte(c.JWTSecret), []byte(c.JWTSecret)
+	case c.JWTPrivateKey != "":
+		buf, err := os.ReadFile(c.JWTPrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(buf)
+		if err != nil {
+			return nil, err
+		}
+		tk.method = jwt.SigningMethodRS256
+		tk.signKey, tk.verifyKey = key, &key.PublicKey
+	case c.JWTPublicKey != "":
+		buf, err := os.ReadFile(c.JWTPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(buf)
+		if err != nil {
+			return nil, err
+		}
+		tk.method = jwt.SigningMethodRS256
+		tk.verifyKey = key
+	default:
+		return nil, nil
+	}
+	return tk, nil
+}
+
+func (tk *tokenKeys) canIssue() bool {
+	return tk != nil && tk.signKey != nil
+}
+
+func (tk *tokenKeys) issue(userID string) (string, error) {
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Subject:   userID,
+		Issuer:    tk.issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(tk.ttl)),
+	}
+	return jwt.NewWithClaims(tk.method, claims).SignedString(tk.signKey)
+}
+
+// Returns user id from valid token
+func (tk *tokenKeys) parse(token string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return tk.verifyKey, nil
+	},
+		jwt.WithValidMethods([]string{tk.method.Alg()}),
+		jwt.WithIssuer(tk.issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenNotValid, err)
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("%w: no subject", ErrTokenNotValid)
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,63 @@
+package mware
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Lets through requests from trusted subnet only. Client address is
+// taken from RemoteAddr, which middleware.RealIP fills from X-Real-IP or
+// X-Forwarded-For, so service must be behind proxy which sets them.
+// Without subnet nobody is trusted.
+
+var ErrNotTrusted = errors.New("client is not in trusted subnet")
+
+type TrustedSubnet struct {
+	subnet *net.IPNet // nil if not set
+}
+
+func NewTrustedSubnet(cidr string) (*TrustedSubnet, error) {
+	ts := &TrustedSubnet{}
+	if cidr == "" {
+		return ts, nil
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ts.subnet = subnet
+	return ts, nil
+}
+
+func (ts *TrustedSubnet) Handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !ts.contains(r.RemoteAddr) {
+			log.Printf("Request from %s is refused: %v", r.RemoteAddr, ErrNotTrusted)
+			http.Error(w, " Error: "+ErrNotTrusted.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (ts *TrustedSubnet) contains(addr string) bool {
+	if ts.subnet == nil {
+		return false
+	}
+	ip := net.ParseIP(clientIP(addr))
+	return ip != nil && ts.subnet.Contains(ip)
+}
+
+// Returns client ip from RemoteAddr
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		// middleware.RealIP puts address without port
+		host = addr
+	}
+	return strings.TrimSpace(host)
+}
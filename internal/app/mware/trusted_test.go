@@ -0,0 +1,79 @@
+package mware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestTrustedSubnet(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	trusted, err := NewTrustedSubnet("10.0.0.0/8")
+	require.NoError(t, err)
+	untrusted, err := NewTrustedSubnet("")
+	require.NoError(t, err)
+	_, err = NewTrustedSubnet("10.0.0.1")
+	assert.Error(t, err)
+
+	tests := []struct {
+		name   string
+		ts     *TrustedSubnet
+		remote string
+		realIP string
+		want   int
+	}{
+		{"real ip in subnet", trusted, "192.168.1.1:4000", "10.1.2.3", http.StatusOK},
+		{"real ip out of subnet", trusted, "10.1.2.3:4000", "192.168.1.1", http.StatusForbidden},
+		{"remote addr in subnet", trusted, "10.1.2.3:4000", "", http.StatusOK},
+		{"bad real ip", trusted, "10.1.2.3:4000", "nonsense", http.StatusForbidden},
+		{"no subnet", untrusted, "10.1.2.3:4000", "10.1.2.3", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			r.RemoteAddr = tt.remote
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			middleware.RealIP(tt.ts.Handler(ok)).ServeHTTP(w, r)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestTrustedSubnet_UnaryInterceptor(t *testing.T) {
+	trusted, err := NewTrustedSubnet("10.0.0.0/8")
+	require.NoError(t, err)
+	interceptor := trusted.UnaryInterceptor("/shortener.Shortener/Stats")
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+	call := func(peerAddr, realIP, method string) codes.Code {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(peerAddr), Port: 4000}})
+		if realIP != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-real-ip", realIP, "x-forwarded-for", realIP))
+		}
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return status.Code(err)
+	}
+
+	assert.Equal(t, codes.OK, call("10.1.2.3", "", "/shortener.Shortener/Stats"))
+	assert.Equal(t, codes.PermissionDenied, call("192.168.1.1", "", "/shortener.Shortener/Stats"))
+	// metadata is set by client, it is not trusted
+	assert.Equal(t, codes.PermissionDenied, call("192.168.1.1", "10.1.2.3", "/shortener.Shortener/Stats"))
+	assert.Equal(t, codes.OK, call("192.168.1.1", "", "/shortener.Shortener/Ping"))
+}
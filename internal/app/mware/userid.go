@@ -4,65 +4,210 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/hmac"
-	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
 )
 
-func UserID(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		var userID string
+// User id is kept in cookie sealed by AES-GCM with random nonce:
+//
+//	<key id>.<hex of nonce and sealed user id>
+//
+// Keys are given as "id:secret" list in COOKIE_SECRET or as lines of key
+// file. Cookies are issued with the first key and accepted with any of
+// them, so to rotate keys new key is put first, and old one is dropped
+// later. Cookie sealed by old key is reissued with the first one.
+// Cookie which can't be opened is replaced by new identity. Without
+// keys in config random secret is made once and kept by repository, so
+// cookies survive restart and are valid in every instance of storage.
+//
+// Valid bearer token (see token.go) is used instead of cookie, request with
+// bad token is refused. New user gets token in response header too.
+// API key in X-API-Key header goes before both of them, it is checked by
+// service, request with unknown or revoked key is refused.
+//
+// gRPC calls carry the same in metadata, see grpc.go.
+
+const (
+	cookieSecretName = "cookie"
+	defaultKeyID     = "0"
+	minSecretLen     = 16
+	userIDByteSize   = 16
+)
+
+var ErrCookieNotValid = errors.New("cookie is not valid")
+
+type cookieKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Finds user id by api key
+type apiKeyChecker interface {
+	UserByAPIKey(ctx context.Context, key string) (string, error)
+}
+
+// Keeps secret made by service, the first stored one wins
+type secretKeeper interface {
+	Secret(ctx context.Context, name, candidate string) (string, error)
+}
+
+type UserID struct {
+	keys    []cookieKey   // the first one is active
+	tokens  *tokenKeys    // nil if tokens are off
+	apiKeys apiKeyChecker // nil if api keys are not accepted
+	secure  bool          // cookie is sent over HTTPS only
+}
 
-		isCookieOk := true
-		usercookie, err := r.Cookie(config.CookieName)
+func NewUserID(c *config.Config, apiKeys apiKeyChecker, secrets secretKeeper) (*UserID, error) {
+	entries, err := cookieSecrets(c, secrets)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := newTokenKeys(c)
+	if err != nil {
+		return nil, err
+	}
+	u := &UserID{tokens: tokens, apiKeys: apiKeys, secure: c.EnableHTTPS}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		id, secret, found := strings.Cut(entry, ":")
+		if !found {
+			id, secret = defaultKeyID, entry
+		}
+		switch {
+		case id == "" || strings.Contains(id, "."):
+			return nil, fmt.Errorf("cookie key id %q is not valid", id)
+		case seen[id]:
+			return nil, fmt.Errorf("cookie key id %q is not unique", id)
+		case len(secret) < minSecretLen:
+			return nil, fmt.Errorf("cookie secret of key %q is shorter than %d chars", id, minSecretLen)
+		}
+		seen[id] = true
+		key := sha256.Sum256([]byte(secret))
+		aesblock, err := aes.NewCipher(key[:])
 		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(aesblock)
+		if err != nil {
+			return nil, err
+		}
+		u.keys = append(u.keys, cookieKey{id: id, aead: aead})
+	}
+	return u, nil
+}
+
+// Returns "id:secret" entries from key file or config. Without them
+// stored secret is used, random one is stored on first start.
+func cookieSecrets(c *config.Config, secrets secretKeeper) ([]string, error) {
+	var entries []string
+	switch {
+	case c.CookieKeyFile != "":
+		buf, err := os.ReadFile(c.CookieKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		entries = strings.Split(string(buf), "\n")
+	case c.CookieSecret != "":
+		entries = strings.Split(c.CookieSecret, ",")
+	}
+	res := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		res = append(res, entry)
+	}
+	if len(res) > 0 {
+		return res, nil
+	}
+	if c.CookieKeyFile != "" {
+		return nil, fmt.Errorf("no keys in cookie key file %s", c.CookieKeyFile)
+	}
+	secret, err := getNewUserID(32)
+	if err != nil {
+		return nil, err
+	}
+	if secrets == nil {
+		log.Println("cookie secret is not set, random one is used and cookies will not survive restart")
+		return []string{secret}, nil
+	}
+	secret, err = secrets.Secret(context.Background(), cookieSecretName, secret)
+	if err != nil {
+		return nil, fmt.Errorf("stored cookie secret: %w", err)
+	}
+	return []string{secret}, nil
+}
+
+func (u *UserID) Handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get(config.APIKeyHeader); key != "" && u.apiKeys != nil {
+			userID, err := u.apiKeys.UserByAPIKey(r.Context(), key)
 			switch {
-			default:
-				log.Println("Error in cookie")
+			case errors.Is(err, config.ErrAPIKeyNotValid):
+				http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusUnauthorized)
+				return
+			case err != nil:
+				log.Printf("Error in api key check: %v\n", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
-			case errors.Is(err, http.ErrNoCookie):
-				isCookieOk = false
 			}
+			ctx := context.WithValue(r.Context(), config.ContextKeyUserID, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
 		}
-		if isCookieOk {
-			userIDcrypt := usercookie.Value
-			userIDcrypt, err = decodeString(userIDcrypt)
+		if token, ok := bearerToken(r); ok && u.tokens != nil {
+			userID, err := u.tokens.parse(token)
 			if err != nil {
-				log.Printf("Error in decode: %v\n", err)
-				w.WriteHeader(http.StatusInternalServerError)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, fmt.Sprintf(" Error: %v", err), http.StatusUnauthorized)
 				return
 			}
-			userID, isCookieOk = unsignString(userIDcrypt)
+			ctx := context.WithValue(r.Context(), config.ContextKeyUserID, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
 		}
 
-		if !isCookieOk {
-			userID, err = getNewUserID(16)
+		var userID string
+		reissue := true
+		if usercookie, err := r.Cookie(config.CookieName); err == nil {
+			var keyID string
+			userID, keyID, err = u.open(usercookie.Value)
+			if err != nil {
+				log.Printf("Error in cookie, new user id is issued: %v\n", err)
+			}
+			reissue = err != nil || keyID != u.keys[0].id
+		}
+		switch {
+		case userID == "":
+			var err error
+			userID, err = getNewUserID(userIDByteSize)
 			if err != nil {
 				log.Printf("Error in rand: %v\n", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			userIDcrypt := signString(userID)
-			userIDcrypt, err = encodeString(userIDcrypt)
-			if err != nil {
+			if err := u.SetUser(w, userID); err != nil {
 				log.Printf("Error in encode: %v\n", err)
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
-			newcookie := http.Cookie{
-				Name:  config.CookieName,
-				Value: userIDcrypt,
-				Path:  "/",
+		case reissue:
+			if err := u.setCookie(w, userID); err != nil {
+				log.Printf("Error in encode: %v\n", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
 			}
-			http.SetCookie(w, &newcookie)
 		}
 
 		ctx := context.WithValue(r.Context(), config.ContextKeyUserID, userID)
@@ -72,85 +217,102 @@ func UserID(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-func getNewUserID(lenbyte int) (string, error) {
-	newID := make([]byte, lenbyte)
-	_, err := rand.Read(newID)
-	if err != nil {
-		return "", err
+// Gives user id to client as cookie, and as token if tokens are issued
+func (u *UserID) SetUser(w http.ResponseWriter, userID string) error {
+	if u.tokens.canIssue() {
+		token, err := u.tokens.issue(userID)
+		if err != nil {
+			return err
+		}
+		w.Header().Set(config.TokenHeader, "Bearer "+token)
 	}
-	return hex.EncodeToString(newID), nil
+	return u.setCookie(w, userID)
 }
 
-func decodeString(msg string) (string, error) {
-	key := sha256.Sum256([]byte(config.PassCiph))
-	aesblock, err := aes.NewCipher(key[:])
-	if err != nil {
-		return "", err
-	}
-	aesgcm, err := cipher.NewGCM(aesblock)
+// Drops cookie, so next request gets new user id. Token can't be
+// revoked, client has to forget it.
+func (u *UserID) ClearUser(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     config.CookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   u.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (u *UserID) setCookie(w http.ResponseWriter, userID string) error {
+	value, err := u.seal(userID)
 	if err != nil {
-		return "", err
+		return err
 	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     config.CookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   u.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
 
-	nonce := key[len(key)-aesgcm.NonceSize():]
+func bearerToken(r *http.Request) (string, bool) {
+	return parseBearer(r.Header.Get(config.TokenHeader))
+}
 
-	buf, err := hex.DecodeString(msg)
-	if err != nil {
-		return "", err
+func parseBearer(value string) (string, bool) {
+	scheme, token, found := strings.Cut(value, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
 	}
+	return strings.TrimSpace(token), true
+}
 
-	encbuf, err := aesgcm.Open(nil, nonce, buf, nil)
-	if err != nil {
+// Seals user id with active key
+func (u *UserID) seal(userID string) (string, error) {
+	key := u.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
 		return "", err
 	}
-
-	return hex.EncodeToString(encbuf), nil
+	sealed := key.aead.Seal(nonce, nonce, []byte(userID), []byte(key.id))
+	return key.id + "." + hex.EncodeToString(sealed), nil
 }
 
-func encodeString(msg string) (string, error) {
-	key := sha256.Sum256([]byte(config.PassCiph))
-	aesblock, err := aes.NewCipher(key[:])
-	if err != nil {
-		return "", err
-	}
-	aesgcm, err := cipher.NewGCM(aesblock)
-	if err != nil {
-		return "", err
+// Returns user id and id of key it was sealed with
+func (u *UserID) open(value string) (string, string, error) {
+	keyID, msg, found := strings.Cut(value, ".")
+	if !found {
+		return "", "", ErrCookieNotValid
 	}
-
-	nonce := key[len(key)-aesgcm.NonceSize():]
-
 	buf, err := hex.DecodeString(msg)
 	if err != nil {
-		return "", err
+		return "", "", ErrCookieNotValid
 	}
-
-	encbuf := aesgcm.Seal(nil, nonce, buf, nil)
-
-	return hex.EncodeToString(encbuf), nil
-}
-
-func signString(msg string) string {
-	buf, _ := hex.DecodeString(msg)
-	key := sha256.Sum256([]byte(config.PassCiph))
-	hm := hmac.New(md5.New, key[:])
-	hm.Write(buf)
-	sign := hex.EncodeToString(hm.Sum(nil))
-	return sign + msg
+	for _, key := range u.keys {
+		if key.id != keyID {
+			continue
+		}
+		if len(buf) < key.aead.NonceSize() {
+			return "", "", ErrCookieNotValid
+		}
+		nonce, sealed := buf[:key.aead.NonceSize()], buf[key.aead.NonceSize():]
+		userID, err := key.aead.Open(nil, nonce, sealed, []byte(key.id))
+		if err != nil || len(userID) == 0 {
+			return "", "", ErrCookieNotValid
+		}
+		return string(userID), keyID, nil
+	}
+	return "", "", fmt.Errorf("%w: unknown key id %q", ErrCookieNotValid, keyID)
 }
 
-func unsignString(msg string) (string, bool) {
-	buf, err := hex.DecodeString(msg)
+func getNewUserID(lenbyte int) (string, error) {
+	newID := make([]byte, lenbyte)
+	_, err := rand.Read(newID)
 	if err != nil {
-		return "", false
-	}
-	key := sha256.Sum256([]byte(config.PassCiph))
-	hm := hmac.New(md5.New, key[:])
-	sign := buf[:md5.Size]
-	hm.Write(buf[md5.Size:])
-	newsign := hm.Sum(nil)
-	if hmac.Equal(sign, newsign) {
-		return msg[2*md5.Size:], true
+		return "", err
 	}
-	return "", false
+	return hex.EncodeToString(newID), nil
 }
@@ -0,0 +1,175 @@
+package mware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+)
+
+// Returns user id seen by handler and cookie set in response, if any
+func serveUserID(t *testing.T, u *UserID, cookie *http.Cookie) (string, *http.Cookie) {
+	var userID string
+	handler := u.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID = r.Context().Value(config.ContextKeyUserID).(string)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == config.CookieName {
+			return userID, c
+		}
+	}
+	return userID, nil
+}
+
+func TestUserID_Rotation(t *testing.T) {
+	old, err := NewUserID(&config.Config{CookieSecret: "v1:first-secret-value"}, nil, nil)
+	require.NoError(t, err)
+	userID, cookie := serveUserID(t, old, nil)
+	require.NotEmpty(t, userID)
+	require.NotNil(t, cookie)
+	assert.Contains(t, cookie.Value, "v1.")
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite, "cookie is not sent by cross-site posts")
+
+	// every cookie has its own nonce
+	_, another := serveUserID(t, old, &http.Cookie{Name: config.CookieName, Value: "garbage"})
+	assert.NotEqual(t, cookie.Value, another.Value)
+
+	seen, reissued := serveUserID(t, old, cookie)
+	assert.Equal(t, userID, seen)
+	assert.Nil(t, reissued)
+
+	// new key is active, old one is still valid
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte("# active key first\nv2:second-secret-value\nv1:first-secret-value\n"), 0600))
+	rotated, err := NewUserID(&config.Config{CookieKeyFile: keyFile}, nil, nil)
+	require.NoError(t, err)
+	seen, reissued = serveUserID(t, rotated, cookie)
+	assert.Equal(t, userID, seen)
+	require.NotNil(t, reissued)
+	assert.Contains(t, reissued.Value, "v2.")
+
+	// old key dropped
+	fresh, err := NewUserID(&config.Config{CookieSecret: "v2:second-secret-value"}, nil, nil)
+	require.NoError(t, err)
+	seen, reissued = serveUserID(t, fresh, cookie)
+	assert.NotEqual(t, userID, seen)
+	assert.NotNil(t, reissued)
+	seen, _ = serveUserID(t, fresh, reissued)
+	assert.NotEmpty(t, seen)
+}
+
+func TestUserID_StoredSecret(t *testing.T) {
+	c := &config.Config{FileStorage: filepath.Join(t.TempDir(), "storage")}
+	first, err := NewUserID(c, nil, repository.New(c))
+	require.NoError(t, err)
+	userID, cookie := serveUserID(t, first, nil)
+	require.NotNil(t, cookie)
+
+	// after restart, or in another instance, cookie is the same user
+	restarted, err := NewUserID(c, nil, repository.New(c))
+	require.NoError(t, err)
+	seen, reissued := serveUserID(t, restarted, cookie)
+	assert.Equal(t, userID, seen)
+	assert.Nil(t, reissued)
+}
+
+func TestNewUserID_BadKeys(t *testing.T) {
+	for _, secret := range []string{"short", "a:long-enough-secret,a:another-long-secret", ".:long-enough-secret"} {
+		_, err := NewUserID(&config.Config{CookieSecret: secret}, nil, nil)
+		assert.Error(t, err, secret)
+	}
+	_, err := NewUserID(&config.Config{CookieKeyFile: filepath.Join(t.TempDir(), "nofile")}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestUserID_Token(t *testing.T) {
+	c := &config.Config{
+		CookieSecret: "cookie-secret-value",
+		JWTSecret:    "token-secret-value",
+		JWTIssuer:    "shortener",
+		JWTTTL:       time.Hour,
+	}
+	u, err := NewUserID(c, nil, nil)
+	require.NoError(t, err)
+
+	serve := func(token string) *httptest.ResponseRecorder {
+		handler := u.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Context().Value(config.ContextKeyUserID).(string)))
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// new user gets token
+	rec := serve("")
+	require.Equal(t, http.StatusOK, rec.Code)
+	userID := rec.Body.String()
+	token, found := strings.CutPrefix(rec.Header().Get(config.TokenHeader), "Bearer ")
+	require.True(t, found)
+
+	rec = serve(token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, userID, rec.Body.String())
+	assert.Empty(t, rec.Header().Get(config.TokenHeader))
+
+	sign := func(method jwt.SigningMethod, key any, claims jwt.RegisteredClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	hour := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	for _, bad := range []string{
+		"garbage",
+		sign(jwt.SigningMethodHS256, []byte(c.JWTSecret), jwt.RegisteredClaims{Subject: "user", Issuer: "shortener", ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}),
+		sign(jwt.SigningMethodHS256, []byte(c.JWTSecret), jwt.RegisteredClaims{Subject: "user", Issuer: "stranger", ExpiresAt: hour}),
+		sign(jwt.SigningMethodHS256, []byte(c.JWTSecret), jwt.RegisteredClaims{Subject: "user", Issuer: "shortener"}),
+		sign(jwt.SigningMethodHS256, []byte("another-secret-value"), jwt.RegisteredClaims{Subject: "user", Issuer: "shortener", ExpiresAt: hour}),
+	} {
+		assert.Equal(t, http.StatusUnauthorized, serve(bad).Code, bad)
+	}
+
+	// RS256, token issued elsewhere is checked by public key
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pubFile := filepath.Join(t.TempDir(), "jwt.pub")
+	require.NoError(t, os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0600))
+	u, err = NewUserID(&config.Config{CookieSecret: c.CookieSecret, JWTPublicKey: pubFile, JWTIssuer: "shortener"}, nil, nil)
+	require.NoError(t, err)
+	rec = serve(sign(jwt.SigningMethodRS256, key, jwt.RegisteredClaims{Subject: "user", Issuer: "shortener", ExpiresAt: hour}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "user", rec.Body.String())
+	// HS256 token signed by public key must not pass
+	assert.Equal(t, http.StatusUnauthorized, serve(sign(jwt.SigningMethodHS256, pub, jwt.RegisteredClaims{Subject: "user", Issuer: "shortener", ExpiresAt: hour})).Code)
+	// only public key, so new users get cookie only
+	rec = serve("")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get(config.TokenHeader))
+}
@@ -0,0 +1,180 @@
+// Package pool runs jobs on fixed number of worker goroutines.
+//
+// Items are submitted to bounded queue, Submit blocks while it is full and
+// TrySubmit fails at once. Every item is passed to job func with context,
+// which is cancelled with pool context or after per-job timeout, so job
+// must watch it to be interrupted. Panic in job is recovered and turned
+// into error. Result of every item goes to Results channel, which must be
+// read until it is closed: it happens after Close, when all queued items
+// are done, or after pool context is cancelled.
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed    = errors.New("pool is closed")
+	ErrQueueFull = errors.New("pool queue is full")
+	ErrPanic     = errors.New("job panicked")
+)
+
+type Func[T any] func(ctx context.Context, item T) error
+
+type Result[T any] struct {
+	Item T
+	Err  error
+}
+
+type Options struct {
+	Size    int           // number of workers, at least one
+	Queue   int           // items waiting for free worker
+	Timeout time.Duration // of one job, 0 is none
+}
+
+type Pool[T any] struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	fn      Func[T]
+	timeout time.Duration
+	queue   chan T
+	results chan Result[T]
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex // guards closing of queue
+	closed bool
+}
+
+// Starts workers, they stop when pool is closed or ctx is cancelled
+func New[T any](ctx context.Context, opts Options, fn Func[T]) *Pool[T] {
+	if opts.Size < 1 {
+		opts.Size = 1
+	}
+	if opts.Queue < 0 {
+		opts.Queue = 0
+	}
+	p := &Pool[T]{
+		fn:      fn,
+		timeout: opts.Timeout,
+		queue:   make(chan T, opts.Queue),
+		results: make(chan Result[T], opts.Size),
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	p.wg.Add(opts.Size)
+	for ik := 0; ik < opts.Size; ik++ {
+		go p.work()
+	}
+	go func() {
+		p.wg.Wait()
+		p.cancel()
+		close(p.results)
+	}()
+	return p
+}
+
+// Queues item, waits while queue is full
+func (p *Pool[T]) Submit(ctx context.Context, item T) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	select {
+	case p.queue <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.ctx.Done():
+		return ErrClosed
+	}
+}
+
+// Queues item if there is room for it
+func (p *Pool[T]) TrySubmit(item T) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed || p.ctx.Err() != nil {
+		return ErrClosed
+	}
+	select {
+	case p.queue <- item:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (p *Pool[T]) Results() <-chan Result[T] {
+	return p.results
+}
+
+// Stops taking new items, queued ones are still done
+func (p *Pool[T]) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+}
+
+func (p *Pool[T]) work() {
+	defer p.wg.Done()
+	for p.ctx.Err() == nil {
+		select {
+		case <-p.ctx.Done():
+			return
+		case item, ok := <-p.queue:
+			if !ok {
+				return
+			}
+			res := p.run(item)
+			select {
+			case p.results <- res:
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (p *Pool[T]) run(item T) (res Result[T]) {
+	res.Item = item
+	ctx := p.ctx
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			res.Err = fmt.Errorf("%w: %v\n%s", ErrPanic, r, debug.Stack())
+		}
+	}()
+	res.Err = p.fn(ctx, item)
+	return res
+}
+
+// Runs fn for all items on pool and returns results in order of completion.
+// Items not done before ctx is cancelled have no results.
+func Process[T any](ctx context.Context, opts Options, items []T, fn Func[T]) []Result[T] {
+	p := New(ctx, opts, fn)
+	go func() {
+		defer p.Close()
+		for _, item := range items {
+			if err := p.Submit(ctx, item); err != nil {
+				return
+			}
+		}
+	}()
+	res := make([]Result[T], 0, len(items))
+	for r := range p.Results() {
+		res = append(res, r)
+	}
+	return res
+}
@@ -0,0 +1,105 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcess(t *testing.T) {
+	items := make([]int, 100)
+	for ik := range items {
+		items[ik] = ik
+	}
+	var running, maxRunning atomic.Int32
+	results := Process(context.Background(), Options{Size: 4}, items, func(ctx context.Context, item int) error {
+		cur := running.Add(1)
+		defer running.Add(-1)
+		for {
+			old := maxRunning.Load()
+			if cur <= old || maxRunning.CompareAndSwap(old, cur) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if item%10 == 0 {
+			return errors.New("bad item")
+		}
+		return nil
+	})
+	require.Len(t, results, len(items))
+	done := make([]int, 0, len(items))
+	failed := 0
+	for _, res := range results {
+		done = append(done, res.Item)
+		if res.Err != nil {
+			failed++
+		}
+	}
+	sort.Ints(done)
+	assert.Equal(t, items, done)
+	assert.Equal(t, 10, failed)
+	assert.LessOrEqual(t, maxRunning.Load(), int32(4))
+}
+
+func TestPool_PanicAndTimeout(t *testing.T) {
+	p := New(context.Background(), Options{Size: 2, Timeout: 10 * time.Millisecond}, func(ctx context.Context, item string) error {
+		switch item {
+		case "panic":
+			panic("boom")
+		case "slow":
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	for _, item := range []string{"panic", "slow", "ok"} {
+		require.NoError(t, p.Submit(context.Background(), item))
+	}
+	p.Close()
+	assert.ErrorIs(t, p.Submit(context.Background(), "late"), ErrClosed)
+
+	errs := make(map[string]error)
+	for res := range p.Results() {
+		errs[res.Item] = res.Err
+	}
+	assert.ErrorIs(t, errs["panic"], ErrPanic)
+	assert.ErrorIs(t, errs["slow"], context.DeadlineExceeded)
+	assert.NoError(t, errs["ok"])
+}
+
+func TestPool_QueueAndCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{}, 2)
+	p := New(ctx, Options{Size: 1, Queue: 1}, func(ctx context.Context, item int) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.NoError(t, p.TrySubmit(1))
+	<-started
+	require.NoError(t, p.TrySubmit(2))
+	assert.ErrorIs(t, p.TrySubmit(3), ErrQueueFull)
+
+	submitCtx, submitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer submitCancel()
+	assert.ErrorIs(t, p.Submit(submitCtx, 3), context.DeadlineExceeded)
+
+	// results channel is closed after cancel, even if nobody reads results
+	cancel()
+	require.Eventually(t, func() bool {
+		select {
+		case _, ok := <-p.Results():
+			return !ok
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	assert.ErrorIs(t, p.TrySubmit(4), ErrClosed)
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Accounts are kept in postgres table, or in file next to records file,
+// or in memory. Email is unique regardless of case.
+
+type accountRepository interface {
+	SaveAccount(ctx context.Context, acc model.Account) error
+	AccountByEmail(ctx context.Context, email string) (model.Account, error)
+	AccountByID(ctx context.Context, id string) (model.Account, error)
+}
+
+const accountFileSuffix = ".accounts"
+
+const accountEmailIndexName = "shrtnr_account_email_idx"
+
+const insertAccountSQL = "INSERT INTO shrtnr_account (id, email, password_hash, created_at) VALUES ($1, $2, $3, $4);"
+const selectAccountByEmailSQL = "SELECT id, email, password_hash, created_at FROM shrtnr_account WHERE lower(email) = lower($1);"
+const selectAccountByIDSQL = "SELECT id, email, password_hash, created_at FROM shrtnr_account WHERE id = $1;"
+
+func (pg *pgSaver) SaveAccount(ctx context.Context, acc model.Account) error {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
+	}
+	_, err = pool.Exec(ctx, insertAccountSQL, acc.ID, acc.Email, acc.PasswordHash, acc.Created)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == accountEmailIndexName {
+		return config.ErrEmailTaken
+	}
+	return err
+}
+
+func (pg *pgSaver) AccountByEmail(ctx context.Context, email string) (model.Account, error) {
+	return pg.account(ctx, selectAccountByEmailSQL, email)
+}
+
+func (pg *pgSaver) AccountByID(ctx context.Context, id string) (model.Account, error) {
+	return pg.account(ctx, selectAccountByIDSQL, id)
+}
+
+func (pg *pgSaver) account(ctx context.Context, sql string, arg string) (model.Account, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return model.Account{}, err
+	}
+	acc := model.Account{}
+	err = pool.QueryRow(ctx, sql, arg).Scan(&acc.ID, &acc.Email, &acc.PasswordHash, &acc.Created)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return acc, config.ErrNoSuchRecord
+	}
+	return acc, err
+}
+
+type memAccounts struct {
+	mu      sync.RWMutex
+	byID    map[string]model.Account
+	byEmail map[string]string // lowercased email -> id
+}
+
+func newMemAccounts() *memAccounts {
+	return &memAccounts{
+		byID:    make(map[string]model.Account),
+		byEmail: make(map[string]string),
+	}
+}
+
+func (ma *memAccounts) SaveAccount(ctx context.Context, acc model.Account) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	return ma.add(acc)
+}
+
+// Must be called with ma.mu locked
+func (ma *memAccounts) add(acc model.Account) error {
+	key := strings.ToLower(acc.Email)
+	if _, ok := ma.byEmail[key]; ok {
+		return config.ErrEmailTaken
+	}
+	ma.byID[acc.ID] = acc
+	ma.byEmail[key] = acc.ID
+	return nil
+}
+
+func (ma *memAccounts) AccountByEmail(ctx context.Context, email string) (model.Account, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	id, ok := ma.byEmail[strings.ToLower(email)]
+	if !ok {
+		return model.Account{}, config.ErrNoSuchRecord
+	}
+	return ma.byID[id], nil
+}
+
+func (ma *memAccounts) AccountByID(ctx context.Context, id string) (model.Account, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	acc, ok := ma.byID[id]
+	if !ok {
+		return model.Account{}, config.ErrNoSuchRecord
+	}
+	return acc, nil
+}
+
+// Accounts are appended to file and read on first use
+type fileAccounts struct {
+	*memAccounts
+	filename string
+	once     sync.Once
+	loadErr  error
+}
+
+func newFileAccounts(filename string) *fileAccounts {
+	return &fileAccounts{
+		memAccounts: newMemAccounts(),
+		filename:    filename + accountFileSuffix,
+	}
+}
+
+func (fa *fileAccounts) SaveAccount(ctx context.Context, acc model.Account) error {
+	if err := fa.load(); err != nil {
+		return err
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	if _, ok := fa.byEmail[strings.ToLower(acc.Email)]; ok {
+		return config.ErrEmailTaken
+	}
+	file, err := os.OpenFile(fa.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(&acc); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return fa.add(acc)
+}
+
+func (fa *fileAccounts) AccountByEmail(ctx context.Context, email string) (model.Account, error) {
+	if err := fa.load(); err != nil {
+		return model.Account{}, err
+	}
+	return fa.memAccounts.AccountByEmail(ctx, email)
+}
+
+func (fa *fileAccounts) AccountByID(ctx context.Context, id string) (model.Account, error) {
+	if err := fa.load(); err != nil {
+		return model.Account{}, err
+	}
+	return fa.memAccounts.AccountByID(ctx, id)
+}
+
+func (fa *fileAccounts) load() error {
+	fa.once.Do(func() {
+		fa.mu.Lock()
+		defer fa.mu.Unlock()
+		// torn last line is account not registered, it is cut off
+		fa.loadErr = readJSONLines(fa.filename, func(decoder *json.Decoder) error {
+			acc := model.Account{}
+			if err := decoder.Decode(&acc); err != nil {
+				return err
+			}
+			fa.add(acc)
+			return nil
+		})
+	})
+	return fa.loadErr
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileAccounts_TornLine(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "storage")
+	fa := newFileAccounts(filename)
+	require.NoError(t, fa.SaveAccount(ctx, model.Account{ID: "acc1", Email: "one@example.com", Created: time.Now()}))
+
+	// crash in the middle of register
+	file, err := os.OpenFile(filename+accountFileSuffix, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"ID":"acc2","Em`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	fa = newFileAccounts(filename)
+	require.NoError(t, fa.SaveAccount(ctx, model.Account{ID: "acc3", Email: "three@example.com", Created: time.Now()}))
+	fa = newFileAccounts(filename)
+	for _, id := range []string{"acc1", "acc3"} {
+		_, err := fa.AccountByID(ctx, id)
+		assert.NoError(t, err, id)
+	}
+}
@@ -0,0 +1,319 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+)
+
+// Admin role is granted to user ids kept in repository (besides ones
+// given by config) and every admin action goes to audit trail. With file
+// storage roles and audit go to files next to records file, both are
+// appended only.
+
+type adminRepository interface {
+	SetAdmin(ctx context.Context, userID string, admin bool) error
+	IsAdmin(ctx context.Context, userID string) (bool, error)
+	SaveAudit(ctx context.Context, entry model.AuditEntry) error
+	AuditLog(ctx context.Context, limit int) ([]model.AuditEntry, error)
+}
+
+const adminFileSuffix = ".admins"
+const auditFileSuffix = ".audit"
+
+const insertAdminSQL = "INSERT INTO shrtnr_admin (userid) VALUES ($1) ON CONFLICT (userid) DO NOTHING;"
+const deleteAdminSQL = "DELETE FROM shrtnr_admin WHERE userid = $1;"
+const selectAdminSQL = "SELECT count(*) FROM shrtnr_admin WHERE userid = $1;"
+const insertAuditSQL = "INSERT INTO shrtnr_audit (at, admin, action, short, userid, details) VALUES ($1, $2, $3, $4, $5, $6);"
+const selectAuditSQL = "SELECT at, admin, action, short, userid, details FROM shrtnr_audit ORDER BY id DESC LIMIT $1;"
+
+func (pg *pgSaver) SetAdmin(ctx context.Context, userID string, admin bool) error {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
+	}
+	sql := insertAdminSQL
+	if !admin {
+		sql = deleteAdminSQL
+	}
+	_, err = pool.Exec(ctx, sql, userID)
+	return err
+}
+
+func (pg *pgSaver) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return false, err
+	}
+	count := 0
+	err = pool.QueryRow(ctx, selectAdminSQL, userID).Scan(&count)
+	return count > 0, err
+}
+
+func (pg *pgSaver) SaveAudit(ctx context.Context, entry model.AuditEntry) error {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
+	}
+	_, err = pool.Exec(ctx, insertAuditSQL, entry.Time, entry.Admin, entry.Action, entry.Short, entry.UserID, entry.Details)
+	return err
+}
+
+func (pg *pgSaver) AuditLog(ctx context.Context, limit int) ([]model.AuditEntry, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := pool.Query(ctx, selectAuditSQL, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]model.AuditEntry, 0)
+	for rows.Next() {
+		entry := model.AuditEntry{}
+		if err := rows.Scan(&entry.Time, &entry.Admin, &entry.Action, &entry.Short, &entry.UserID, &entry.Details); err != nil {
+			return nil, err
+		}
+		res = append(res, entry)
+	}
+	return res, rows.Err()
+}
+
+type memAdmins struct {
+	mu     sync.RWMutex
+	admins map[string]bool
+	audit  []model.AuditEntry
+}
+
+func newMemAdmins() *memAdmins {
+	return &memAdmins{
+		admins: make(map[string]bool),
+	}
+}
+
+func (ma *memAdmins) SetAdmin(ctx context.Context, userID string, admin bool) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	ma.setAdmin(userID, admin)
+	return nil
+}
+
+// Must be called with ma.mu locked
+func (ma *memAdmins) setAdmin(userID string, admin bool) {
+	if admin {
+		ma.admins[userID] = true
+	} else {
+		delete(ma.admins, userID)
+	}
+}
+
+func (ma *memAdmins) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	return ma.admins[userID], nil
+}
+
+func (ma *memAdmins) SaveAudit(ctx context.Context, entry model.AuditEntry) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+	ma.audit = append(ma.audit, entry)
+	return nil
+}
+
+// Returns last entries, newest first
+func (ma *memAdmins) AuditLog(ctx context.Context, limit int) ([]model.AuditEntry, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+	res := make([]model.AuditEntry, 0, limit)
+	for ik := len(ma.audit) - 1; ik >= 0 && len(res) < limit; ik-- {
+		res = append(res, ma.audit[ik])
+	}
+	return res, nil
+}
+
+type adminRecord struct {
+	UserID string `json:"USERID"`
+	Admin  bool   `json:"ADMIN"`
+}
+
+type fileAdmins struct {
+	*memAdmins
+	adminFile string
+	auditFile string
+	once      sync.Once
+	loadErr   error
+}
+
+func newFileAdmins(filename string) *fileAdmins {
+	return &fileAdmins{
+		memAdmins: newMemAdmins(),
+		adminFile: filename + adminFileSuffix,
+		auditFile: filename + auditFileSuffix,
+	}
+}
+
+func (fa *fileAdmins) SetAdmin(ctx context.Context, userID string, admin bool) error {
+	if err := fa.load(); err != nil {
+		return err
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	if err := appendJSON(fa.adminFile, adminRecord{UserID: userID, Admin: admin}); err != nil {
+		return err
+	}
+	fa.setAdmin(userID, admin)
+	return nil
+}
+
+func (fa *fileAdmins) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	if err := fa.load(); err != nil {
+		return false, err
+	}
+	return fa.memAdmins.IsAdmin(ctx, userID)
+}
+
+func (fa *fileAdmins) SaveAudit(ctx context.Context, entry model.AuditEntry) error {
+	if err := fa.load(); err != nil {
+		return err
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	if err := appendJSON(fa.auditFile, entry); err != nil {
+		return err
+	}
+	fa.audit = append(fa.audit, entry)
+	return nil
+}
+
+func (fa *fileAdmins) AuditLog(ctx context.Context, limit int) ([]model.AuditEntry, error) {
+	if err := fa.load(); err != nil {
+		return nil, err
+	}
+	return fa.memAdmins.AuditLog(ctx, limit)
+}
+
+func (fa *fileAdmins) load() error {
+	fa.once.Do(func() {
+		fa.mu.Lock()
+		defer fa.mu.Unlock()
+		fa.loadErr = readJSONLines(fa.adminFile, func(decoder *json.Decoder) error {
+			rec := adminRecord{}
+			if err := decoder.Decode(&rec); err != nil {
+				return err
+			}
+			fa.setAdmin(rec.UserID, rec.Admin)
+			return nil
+		})
+		if fa.loadErr != nil {
+			return
+		}
+		fa.loadErr = readJSONLines(fa.auditFile, func(decoder *json.Decoder) error {
+			entry := model.AuditEntry{}
+			if err := decoder.Decode(&entry); err != nil {
+				return err
+			}
+			fa.audit = append(fa.audit, entry)
+			return nil
+		})
+	})
+	return fa.loadErr
+}
+
+func appendJSON(filename string, v any) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(v); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Calls fn for every line of file. Torn last line, left by crash in the
+// middle of append, is cut off, otherwise lines appended after it would
+// be lost on next read. Missing file is empty.
+func readJSONLines(filename string, fn func(decoder *json.Decoder) error) error {
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	good := int64(0) // end of last good line
+	for decoder.More() {
+		if err := fn(decoder); err != nil {
+			log.Printf("torn line in %s is cut off at %d: %v", filename, good, err)
+			return file.Truncate(good)
+		}
+		good = decoder.InputOffset()
+		// value is followed by newline written by encoder
+		next := make([]byte, 1)
+		if n, _ := file.ReadAt(next, good); n == 1 && next[0] == '\n' {
+			good++
+		}
+	}
+	return nil
+}
+
+// Grants or revokes admin role from command line, user is given by id
+// or by account email:
+//
+//	shortener -f urls.stor admin grant user@example.com
+//	shortener -d postgres://... admin revoke 5f2b...
+//
+// With postgres running server sees the change at once. With file
+// storage server reads admins file once, so it sees the change only
+// after restart.
+func AdminCommand(ctx context.Context, c *config.Config, args []string) error {
+	if len(args) != 2 || (args[0] != "grant" && args[0] != "revoke") {
+		return errors.New("usage: admin grant|revoke <user id or email>")
+	}
+	if c.PgConnString == "" && c.FileStorage == "" {
+		return errors.New("admin role needs storage, set DATABASE_DSN or FILE_STORAGE_PATH")
+	}
+	r := New(c)
+	if pg, ok := r.ms.(*pgSaver); ok {
+		if _, err := pg.ensurePool(); err != nil {
+			return err
+		}
+		defer pg.Close()
+	}
+
+	userID := args[1]
+	if strings.Contains(userID, "@") {
+		acc, err := r.AccountByEmail(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", userID, err)
+		}
+		userID = acc.ID
+	}
+	grant := args[0] == "grant"
+	if err := r.SetAdmin(ctx, userID, grant); err != nil {
+		return err
+	}
+	action := model.AuditGrantAdmin
+	if !grant {
+		action = model.AuditRevokeAdmin
+	}
+	return r.SaveAudit(ctx, model.AuditEntry{
+		Time:   time.Now().UTC(),
+		Admin:  "cli",
+		Action: action,
+		UserID: userID,
+	})
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminCommand(t *testing.T) {
+	ctx := context.Background()
+	c := &config.Config{FileStorage: filepath.Join(t.TempDir(), "storage")}
+	require.NoError(t, New(c).SaveAccount(ctx, model.Account{ID: "acc1", Email: "admin@example.com", Created: time.Now()}))
+
+	assert.Error(t, AdminCommand(ctx, c, []string{"grant"}))
+	assert.Error(t, AdminCommand(ctx, c, []string{"grant", "nobody@example.com"}))
+	require.NoError(t, AdminCommand(ctx, c, []string{"grant", "admin@example.com"}))
+	require.NoError(t, AdminCommand(ctx, c, []string{"grant", "user2"}))
+	require.NoError(t, AdminCommand(ctx, c, []string{"revoke", "user2"}))
+
+	r := New(c)
+	for userID, want := range map[string]bool{"acc1": true, "user2": false} {
+		admin, err := r.IsAdmin(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, want, admin, userID)
+	}
+	entries, err := r.AuditLog(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, model.AuditRevokeAdmin, entries[0].Action)
+	assert.Equal(t, "acc1", entries[2].UserID)
+}
+
+func TestFileAdmins_TornLine(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "storage")
+	fa := newFileAdmins(filename)
+	require.NoError(t, fa.SetAdmin(ctx, "user1", true))
+	require.NoError(t, fa.SetAdmin(ctx, "user2", true))
+
+	// crash in the middle of revoke
+	file, err := os.OpenFile(filename+adminFileSuffix, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"USERID":"user2","AD`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	fa = newFileAdmins(filename)
+	require.NoError(t, fa.SetAdmin(ctx, "user1", false))
+	fa = newFileAdmins(filename)
+	for userID, want := range map[string]bool{"user1": false, "user2": true} {
+		admin, err := fa.IsAdmin(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, want, admin, userID)
+	}
+	data, err := os.ReadFile(filename + adminFileSuffix)
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"), "lines are whole")
+}
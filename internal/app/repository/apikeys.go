@@ -0,0 +1,289 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// API keys are kept like accounts: in postgres table, or in file next
+// to records file, or in memory. Keys are found by hash. With file storage
+// every change appends key to file, last copy wins, file is rewritten on load.
+
+type apiKeyRepository interface {
+	SaveAPIKey(ctx context.Context, key model.APIKey) error
+	APIKeyByHash(ctx context.Context, hash string) (model.APIKey, error)
+	APIKeysByUser(ctx context.Context, userID string) ([]model.APIKey, error)
+	TouchAPIKey(ctx context.Context, id string, used time.Time) error
+}
+
+const apiKeyFileSuffix = ".apikeys"
+
+const upsertAPIKeySQL = `INSERT INTO shrtnr_api_key (id, userid, name, prefix, hash, created_at, last_used_at, revoked_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, revoked_at = EXCLUDED.revoked_at;`
+const selectAPIKeyByHashSQL = "SELECT id, userid, name, prefix, hash, created_at, last_used_at, revoked_at FROM shrtnr_api_key WHERE hash = $1;"
+const selectAPIKeysByUserSQL = "SELECT id, userid, name, prefix, hash, created_at, last_used_at, revoked_at FROM shrtnr_api_key WHERE userid = $1 ORDER BY created_at;"
+const touchAPIKeySQL = "UPDATE shrtnr_api_key SET last_used_at = $2 WHERE id = $1;"
+
+func (pg *pgSaver) SaveAPIKey(ctx context.Context, key model.APIKey) error {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
+	}
+	_, err = pool.Exec(ctx, upsertAPIKeySQL, key.ID, key.UserID, key.Name, key.Prefix, key.Hash,
+		key.Created, key.LastUsed, key.Revoked)
+	return err
+}
+
+func (pg *pgSaver) APIKeyByHash(ctx context.Context, hash string) (model.APIKey, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	key := model.APIKey{}
+	err = pool.QueryRow(ctx, selectAPIKeyByHashSQL, hash).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix,
+		&key.Hash, &key.Created, &key.LastUsed, &key.Revoked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return key, config.ErrNoSuchRecord
+	}
+	return key, err
+}
+
+func (pg *pgSaver) APIKeysByUser(ctx context.Context, userID string) ([]model.APIKey, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := pool.Query(ctx, selectAPIKeysByUserSQL, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]model.APIKey, 0)
+	for rows.Next() {
+		key := model.APIKey{}
+		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash,
+			&key.Created, &key.LastUsed, &key.Revoked); err != nil {
+			return nil, err
+		}
+		res = append(res, key)
+	}
+	return res, rows.Err()
+}
+
+func (pg *pgSaver) TouchAPIKey(ctx context.Context, id string, used time.Time) error {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
+	}
+	_, err = pool.Exec(ctx, touchAPIKeySQL, id, used)
+	return err
+}
+
+type memAPIKeys struct {
+	mu     sync.RWMutex
+	byID   map[string]model.APIKey
+	byHash map[string]string // hash -> id
+}
+
+func newMemAPIKeys() *memAPIKeys {
+	return &memAPIKeys{
+		byID:   make(map[string]model.APIKey),
+		byHash: make(map[string]string),
+	}
+}
+
+func (mk *memAPIKeys) SaveAPIKey(ctx context.Context, key model.APIKey) error {
+	mk.mu.Lock()
+	defer mk.mu.Unlock()
+	mk.put(mk.keepLastUsed(key))
+	return nil
+}
+
+// Last use is changed by TouchAPIKey only.
+// Must be called with mk.mu locked.
+func (mk *memAPIKeys) keepLastUsed(key model.APIKey) model.APIKey {
+	if old, ok := mk.byID[key.ID]; ok {
+		key.LastUsed = old.LastUsed
+	}
+	return key
+}
+
+// Must be called with mk.mu locked
+func (mk *memAPIKeys) put(key model.APIKey) {
+	mk.byID[key.ID] = key
+	mk.byHash[key.Hash] = key.ID
+}
+
+func (mk *memAPIKeys) APIKeyByHash(ctx context.Context, hash string) (model.APIKey, error) {
+	mk.mu.RLock()
+	defer mk.mu.RUnlock()
+	id, ok := mk.byHash[hash]
+	if !ok {
+		return model.APIKey{}, config.ErrNoSuchRecord
+	}
+	return mk.byID[id], nil
+}
+
+func (mk *memAPIKeys) APIKeysByUser(ctx context.Context, userID string) ([]model.APIKey, error) {
+	mk.mu.RLock()
+	defer mk.mu.RUnlock()
+	res := make([]model.APIKey, 0)
+	for _, key := range mk.byID {
+		if key.UserID == userID {
+			res = append(res, key)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Created.Before(res[j].Created) })
+	return res, nil
+}
+
+func (mk *memAPIKeys) TouchAPIKey(ctx context.Context, id string, used time.Time) error {
+	mk.mu.Lock()
+	defer mk.mu.Unlock()
+	_, err := mk.touch(id, used)
+	return err
+}
+
+// Must be called with mk.mu locked
+func (mk *memAPIKeys) touch(id string, used time.Time) (model.APIKey, error) {
+	key, ok := mk.byID[id]
+	if !ok {
+		return key, config.ErrNoSuchRecord
+	}
+	key.LastUsed = &used
+	mk.byID[id] = key
+	return key, nil
+}
+
+type fileAPIKeys struct {
+	*memAPIKeys
+	filename string
+	once     sync.Once
+	loadErr  error
+}
+
+func newFileAPIKeys(filename string) *fileAPIKeys {
+	return &fileAPIKeys{
+		memAPIKeys: newMemAPIKeys(),
+		filename:   filename + apiKeyFileSuffix,
+	}
+}
+
+func (fk *fileAPIKeys) SaveAPIKey(ctx context.Context, key model.APIKey) error {
+	if err := fk.load(); err != nil {
+		return err
+	}
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+	key = fk.keepLastUsed(key)
+	if err := fk.appendKey(key); err != nil {
+		return err
+	}
+	fk.put(key)
+	return nil
+}
+
+func (fk *fileAPIKeys) APIKeyByHash(ctx context.Context, hash string) (model.APIKey, error) {
+	if err := fk.load(); err != nil {
+		return model.APIKey{}, err
+	}
+	return fk.memAPIKeys.APIKeyByHash(ctx, hash)
+}
+
+func (fk *fileAPIKeys) APIKeysByUser(ctx context.Context, userID string) ([]model.APIKey, error) {
+	if err := fk.load(); err != nil {
+		return nil, err
+	}
+	return fk.memAPIKeys.APIKeysByUser(ctx, userID)
+}
+
+func (fk *fileAPIKeys) TouchAPIKey(ctx context.Context, id string, used time.Time) error {
+	if err := fk.load(); err != nil {
+		return err
+	}
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+	old := fk.byID[id]
+	key, err := fk.touch(id, used)
+	if err != nil {
+		return err
+	}
+	if err := fk.appendKey(key); err != nil {
+		fk.put(old)
+		return err
+	}
+	return nil
+}
+
+// Must be called with fk.mu locked
+func (fk *fileAPIKeys) appendKey(key model.APIKey) error {
+	file, err := os.OpenFile(fk.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(&key); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func (fk *fileAPIKeys) load() error {
+	fk.once.Do(func() {
+		fk.loadErr = fk.readFile()
+	})
+	return fk.loadErr
+}
+
+func (fk *fileAPIKeys) readFile() error {
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+	file, err := os.Open(fk.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		key := model.APIKey{}
+		if err := decoder.Decode(&key); err != nil {
+			// torn last line, the change was not confirmed
+			break
+		}
+		fk.put(key)
+	}
+	file.Close()
+
+	tmpName := fk.filename + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+	defer tmp.Close()
+	encoder := json.NewEncoder(tmp)
+	for _, key := range fk.byID {
+		if err := encoder.Encode(&key); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fk.filename)
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+)
+
+// Clicks are stored apart from short url records. Postgres keeps them in
+// its own table (see pgsaver.go), with file storage they go to file next
+// to records file, without any storage they live in memory.
+
+type clickRepository interface {
+	SaveClicks(ctx context.Context, data []model.Click) error
+	ClickStats(ctx context.Context, short string, bucket string) (model.ClickStats, error)
+	ClickCount(ctx context.Context) (int, error)
+}
+
+const clickFileSuffix = ".clicks"
+
+// Clicks file is read once, torn last line is cut off, and then clicks
+// are counted in memory and appended to file.
+type fileClickSaver struct {
+	*memClickSaver
+	filename string
+	once     sync.Once
+	loadErr  error
+}
+
+func newFileClickSaver(filename string) *fileClickSaver {
+	return &fileClickSaver{
+		memClickSaver: newMemClickSaver(),
+		filename:      filename + clickFileSuffix,
+	}
+}
+
+func (fs *fileClickSaver) SaveClicks(ctx context.Context, data []model.Click) error {
+	if err := fs.load(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	file, err := os.OpenFile(fs.filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	for ik := range data {
+		if err := encoder.Encode(&data[ik]); err != nil {
+			return err
+		}
+		fs.add(data[ik])
+	}
+	return nil
+}
+
+func (fs *fileClickSaver) ClickStats(ctx context.Context, short string, bucket string) (model.ClickStats, error) {
+	if err := fs.load(); err != nil {
+		return model.ClickStats{}, err
+	}
+	return fs.memClickSaver.ClickStats(ctx, short, bucket)
+}
+
+func (fs *fileClickSaver) ClickCount(ctx context.Context) (int, error) {
+	if err := fs.load(); err != nil {
+		return 0, err
+	}
+	return fs.memClickSaver.ClickCount(ctx)
+}
+
+func (fs *fileClickSaver) load() error {
+	fs.once.Do(func() {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		fs.loadErr = readJSONLines(fs.filename, func(decoder *json.Decoder) error {
+			click := model.Click{}
+			if err := decoder.Decode(&click); err != nil {
+				return err
+			}
+			fs.add(click)
+			return nil
+		})
+	})
+	return fs.loadErr
+}
+
+type memClickSaver struct {
+	mu     sync.RWMutex
+	clicks map[string][]time.Time
+	total  int
+}
+
+func newMemClickSaver() *memClickSaver {
+	return &memClickSaver{
+		clicks: make(map[string][]time.Time),
+	}
+}
+
+func (ms *memClickSaver) SaveClicks(ctx context.Context, data []model.Click) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, click := range data {
+		ms.add(click)
+	}
+	return nil
+}
+
+// Must be called with ms.mu locked
+func (ms *memClickSaver) add(click model.Click) {
+	ms.clicks[click.Short] = append(ms.clicks[click.Short], click.Time)
+	ms.total++
+}
+
+func (ms *memClickSaver) ClickStats(ctx context.Context, short string, bucket string) (model.ClickStats, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	stats := newClickStats(bucket)
+	for _, tm := range ms.clicks[short] {
+		stats.add(tm)
+	}
+	return stats.result(short, bucket), nil
+}
+
+func (ms *memClickSaver) ClickCount(ctx context.Context) (int, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.total, nil
+}
+
+// Counts clicks by time buckets for savers which can't do it themselves
+type clickStats struct {
+	size   time.Duration
+	counts map[time.Time]int
+	total  int
+}
+
+func newClickStats(bucket string) *clickStats {
+	return &clickStats{
+		size:   config.StatBuckets[bucket],
+		counts: make(map[time.Time]int),
+	}
+}
+
+func (cs *clickStats) add(tm time.Time) {
+	cs.counts[tm.UTC().Truncate(cs.size)]++
+	cs.total++
+}
+
+func (cs *clickStats) result(short string, bucket string) model.ClickStats {
+	res := model.ClickStats{
+		ShortURL: short,
+		Bucket:   bucket,
+		Total:    cs.total,
+		Buckets:  make([]model.ClickBucket, 0, len(cs.counts)),
+	}
+	for start, count := range cs.counts {
+		res.Buckets = append(res.Buckets, model.ClickBucket{Start: start, Count: count})
+	}
+	sort.Slice(res.Buckets, func(i, j int) bool {
+		return res.Buckets[i].Start.Before(res.Buckets[j].Start)
+	})
+	return res
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileClickSaver_TornLine(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "storage")
+	now := time.Now()
+	fs := newFileClickSaver(filename)
+	require.NoError(t, fs.SaveClicks(ctx, []model.Click{{Short: "abcde", Time: now}, {Short: "fghij", Time: now}}))
+
+	// crash in the middle of flush
+	file, err := os.OpenFile(filename+clickFileSuffix, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"SHORT":"abcde","TI`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	fs = newFileClickSaver(filename)
+	stats, err := fs.ClickStats(ctx, "abcde", "day")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Total)
+	require.NoError(t, fs.SaveClicks(ctx, []model.Click{{Short: "abcde", Time: now}}))
+
+	fs = newFileClickSaver(filename)
+	count, err := fs.ClickCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	stats, err = fs.ClickStats(ctx, "abcde", "day")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Total)
+}
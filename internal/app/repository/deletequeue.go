@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/jackc/pgx/v5"
+)
+
+// Deletion jobs are queued in repository, so they survive restart: service
+// saves job before replying to user, saves its progress after every attempt
+// and takes not done jobs on start. Postgres keeps them in table, with file
+// storage they go to file next to records file, last copy of job wins.
+// Without any storage they live in memory only.
+
+type deleteRepository interface {
+	SaveDeleteJob(ctx context.Context, job model.DeleteJob) error
+	GetDeleteJob(ctx context.Context, id string) (model.DeleteJob, error)
+	PendingDeleteJobs(ctx context.Context) ([]model.DeleteJob, error)
+}
+
+const deleteFileSuffix = ".deletes"
+
+// Done jobs are kept that long for status requests
+const deleteJobKeep = 24 * time.Hour
+
+const upsertDeleteJobSQL = `INSERT INTO shrtnr_delete_job (id, userid, job, done) VALUES ($1, $2, $3, $4)
+ON CONFLICT (id) DO UPDATE SET job = EXCLUDED.job, done = EXCLUDED.done, updated_at = now();`
+const selectDeleteJobSQL = "SELECT job FROM shrtnr_delete_job WHERE id = $1;"
+const selectPendingJobsSQL = "SELECT job FROM shrtnr_delete_job WHERE NOT done ORDER BY created_at;"
+
+func (pg *pgSaver) SaveDeleteJob(ctx context.Context, job model.DeleteJob) error {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
+	}
+	_, err = pool.Exec(ctx, upsertDeleteJobSQL, job.ID, job.UserID, job, job.Done)
+	return err
+}
+
+func (pg *pgSaver) GetDeleteJob(ctx context.Context, id string) (model.DeleteJob, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return model.DeleteJob{}, err
+	}
+	job := model.DeleteJob{}
+	err = pool.QueryRow(ctx, selectDeleteJobSQL, id).Scan(&job)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return job, config.ErrNoSuchRecord
+	}
+	return job, err
+}
+
+func (pg *pgSaver) PendingDeleteJobs(ctx context.Context) ([]model.DeleteJob, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := pool.Query(ctx, selectPendingJobsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make([]model.DeleteJob, 0)
+	for rows.Next() {
+		job := model.DeleteJob{}
+		if err := rows.Scan(&job); err != nil {
+			return nil, err
+		}
+		res = append(res, job)
+	}
+	return res, rows.Err()
+}
+
+type memDeleteQueue struct {
+	mu   sync.Mutex
+	jobs map[string]model.DeleteJob
+}
+
+func newMemDeleteQueue() *memDeleteQueue {
+	return &memDeleteQueue{
+		jobs: make(map[string]model.DeleteJob),
+	}
+}
+
+func (mq *memDeleteQueue) SaveDeleteJob(ctx context.Context, job model.DeleteJob) error {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	mq.jobs[job.ID] = copyJob(job)
+	return nil
+}
+
+func (mq *memDeleteQueue) GetDeleteJob(ctx context.Context, id string) (model.DeleteJob, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	job, ok := mq.jobs[id]
+	if !ok {
+		return job, config.ErrNoSuchRecord
+	}
+	return copyJob(job), nil
+}
+
+func (mq *memDeleteQueue) PendingDeleteJobs(ctx context.Context) ([]model.DeleteJob, error) {
+	mq.mu.Lock()
+	defer mq.mu.Unlock()
+	res := make([]model.DeleteJob, 0)
+	for _, job := range mq.jobs {
+		if !job.Done {
+			res = append(res, copyJob(job))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Created.Before(res[j].Created) })
+	return res, nil
+}
+
+// Jobs are appended to file on every save and read back on start,
+// then file is rewritten without done jobs older than deleteJobKeep.
+type fileDeleteQueue struct {
+	*memDeleteQueue
+	filename string
+	once     sync.Once
+	loadErr  error
+}
+
+func newFileDeleteQueue(filename string) *fileDeleteQueue {
+	return &fileDeleteQueue{
+		memDeleteQueue: newMemDeleteQueue(),
+		filename:       filename + deleteFileSuffix,
+	}
+}
+
+func (fq *fileDeleteQueue) SaveDeleteJob(ctx context.Context, job model.DeleteJob) error {
+	if err := fq.load(); err != nil {
+		return err
+	}
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	file, err := os.OpenFile(fq.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(&job); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	fq.jobs[job.ID] = copyJob(job)
+	return nil
+}
+
+func (fq *fileDeleteQueue) GetDeleteJob(ctx context.Context, id string) (model.DeleteJob, error) {
+	if err := fq.load(); err != nil {
+		return model.DeleteJob{}, err
+	}
+	return fq.memDeleteQueue.GetDeleteJob(ctx, id)
+}
+
+func (fq *fileDeleteQueue) PendingDeleteJobs(ctx context.Context) ([]model.DeleteJob, error) {
+	if err := fq.load(); err != nil {
+		return nil, err
+	}
+	return fq.memDeleteQueue.PendingDeleteJobs(ctx)
+}
+
+func (fq *fileDeleteQueue) load() error {
+	fq.once.Do(func() {
+		fq.loadErr = fq.readFile()
+	})
+	return fq.loadErr
+}
+
+func (fq *fileDeleteQueue) readFile() error {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	file, err := os.Open(fq.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		job := model.DeleteJob{}
+		if err := decoder.Decode(&job); err != nil {
+			// torn last line, the job was saved again or it is lost anyway
+			break
+		}
+		fq.jobs[job.ID] = job
+	}
+	file.Close()
+
+	tmpName := fq.filename + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+	defer tmp.Close()
+	encoder := json.NewEncoder(tmp)
+	for id, job := range fq.jobs {
+		if job.Done && time.Since(job.Created) > deleteJobKeep {
+			delete(fq.jobs, id)
+			continue
+		}
+		if err := encoder.Encode(&job); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fq.filename)
+}
+
+func copyJob(job model.DeleteJob) model.DeleteJob {
+	res := job
+	res.Shorts = append([]string(nil), job.Shorts...)
+	res.Results = make(map[string]string, len(job.Results))
+	for short, result := range job.Results {
+		res.Results[short] = result
+	}
+	return res
+}
@@ -3,43 +3,110 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
 )
 
+// File storage is snapshot (storage file) plus write-ahead log (see wal.go).
+// Changed records are appended to log again and on load the last copy wins,
+// so from time to time storage is compacted: active log segment is sealed,
+// actual copy of every record from snapshot and sealed segments is written
+// to temp file, which then replaces snapshot by rename, and sealed segments
+// are removed. Writers go on to the next segment meanwhile. If crash comes
+// before segments are removed, replaying them again gives the same records.
+//
+// On start storage is recovered: torn or corrupt tail of the last segment
+// (or of snapshot written by older version) is cut off with a log message.
+
 type diskSaver struct {
-	filename string
-	file     *os.File
+	filename   string
+	walDir     string
+	fsync      string
+	fsyncEvery time.Duration
+	segSize    int64
+	minSize    int64
+	ratio      float64
+
+	mu       sync.Mutex // guards fields below
+	active   *os.File   // log segment open for append, nil until recovered
+	seq      uint64     // number of active segment
+	segBytes int64      // size of active segment
+	walBytes int64      // size of all segments
+	dirty    bool       // active segment has writes not synced
+	lines    int        // records in snapshot and log, stale copies included
+	keys     map[string]struct{}
+	stop     chan struct{}
+
+	compacting atomic.Bool
 }
 
-func newDiskSaver(filename string) *diskSaver {
-	if filename == "" {
+func newDiskSaver(c *config.Config) *diskSaver {
+	if c.FileStorage == "" {
 		return nil
 	}
 	return &diskSaver{
-		filename: filename,
+		filename:   c.FileStorage,
+		walDir:     c.FileStorage + segmentExt,
+		fsync:      c.Fsync,
+		fsyncEvery: c.FsyncEvery,
+		segSize:    c.WALSegment,
+		minSize:    c.CompactSize,
+		ratio:      c.CompactRatio,
+		keys:       make(map[string]struct{}),
 	}
 }
 
 func (ds *diskSaver) Save(ctx context.Context, data model.ShortURL) error {
-	if err := ds.openFile(); err != nil {
+	return ds.SaveBatch(ctx, []*model.ShortURL{&data})
+}
+
+func (ds *diskSaver) SaveBatch(ctx context.Context, data []*model.ShortURL) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if err := ds.open(); err != nil {
 		return err
 	}
-	defer ds.closeFile()
-	encoder := json.NewEncoder(ds.file)
-	if err := encoder.Encode(&data); err != nil {
+	buf := make([]byte, 0, 256*len(data))
+	for _, rec := range data {
+		var err error
+		if buf, err = appendFrame(buf, rec); err != nil {
+			return err
+		}
+	}
+	if _, err := ds.active.Write(buf); err != nil {
+		// don't leave torn frame before next writes
+		ds.active.Truncate(ds.segBytes)
 		return err
 	}
-	return nil
-}
-
-func (ds *diskSaver) SaveBatch(ctx context.Context, data []*model.ShortURL) error {
+	ds.segBytes += int64(len(buf))
+	ds.walBytes += int64(len(buf))
+	ds.dirty = true
 	for _, rec := range data {
-		if err := ds.Save(ctx, *rec); err != nil {
+		ds.lines++
+		ds.keys[rec.Short] = struct{}{}
+	}
+	if ds.fsync == config.FsyncAlways {
+		if err := ds.syncActive(); err != nil {
+			return err
+		}
+	}
+	if ds.segSize > 0 && ds.segBytes >= ds.segSize {
+		if err := ds.rotate(); err != nil {
 			return err
 		}
 	}
+	ds.compactIfNeeded()
 	return nil
 }
 
@@ -48,41 +115,323 @@ func (ds *diskSaver) UpdateBatch(ctx context.Context, data []*model.ShortURL) er
 }
 
 func (ds *diskSaver) Load(ctx context.Context, data map[string]*model.ShortURL) error {
-	if err := ds.openFile(); err != nil {
-		return err
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.recover(func(rec *model.ShortURL) {
+		data[rec.Short] = rec
+	})
+}
+
+func (ds *diskSaver) Ping(ctx context.Context) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.open()
+}
+
+// Syncs and closes active segment
+func (ds *diskSaver) Close() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.stop != nil {
+		close(ds.stop)
+		ds.stop = nil
+	}
+	if ds.active == nil {
+		return nil
+	}
+	ds.dirty = true
+	err := ds.syncActive()
+	if errClose := ds.active.Close(); err == nil {
+		err = errClose
 	}
-	defer ds.closeFile()
+	ds.active = nil
+	return err
+}
+
+// Recovers storage on first use. Must be called with ds.mu locked.
+func (ds *diskSaver) open() error {
+	if ds.active != nil {
+		return nil
+	}
+	return ds.recover(nil)
+}
 
-	decoder := json.NewDecoder(ds.file)
-	for decoder.More() {
-		shortRec := &model.ShortURL{}
-		if err := decoder.Decode(&shortRec); err != nil {
+// Replays snapshot and log segments, cutting off corrupt tail,
+// and opens the last segment for append. Must be called with ds.mu locked.
+func (ds *diskSaver) recover(fn func(rec *model.ShortURL)) error {
+	if ds.active != nil {
+		ds.active.Close()
+		ds.active = nil
+	}
+	ds.lines, ds.walBytes = 0, 0
+	ds.keys = make(map[string]struct{})
+	count := func(rec *model.ShortURL) {
+		ds.lines++
+		ds.keys[rec.Short] = struct{}{}
+		if fn != nil {
+			fn(rec)
+		}
+	}
+
+	if err := ds.replaySnapshot(count); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(ds.walDir, 0777); err != nil {
+		return err
+	}
+	seqs, err := listSegments(ds.walDir)
+	if err != nil {
+		return err
+	}
+	for i, seq := range seqs {
+		size, err := ds.replaySegment(seq, i == len(seqs)-1, count)
+		if err != nil {
 			return err
 		}
-		data[shortRec.Short] = shortRec
+		ds.walBytes += size
+	}
+	seq := uint64(1)
+	if len(seqs) > 0 {
+		seq = seqs[len(seqs)-1]
+	}
+	if err := ds.openSegment(seq); err != nil {
+		return err
+	}
+	if ds.fsync == config.FsyncInterval && ds.stop == nil {
+		ds.stop = make(chan struct{})
+		go ds.syncLoop(ds.stop)
 	}
 	return nil
 }
 
-func (ds *diskSaver) Ping(ctx context.Context) error {
-	if err := ds.openFile(); err != nil {
+func (ds *diskSaver) replaySnapshot(fn func(rec *model.ShortURL)) error {
+	file, err := os.OpenFile(ds.filename, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	good, err := readLines(file, fn)
+	if !errors.Is(err, errCorruptRecord) {
 		return err
 	}
-	if err := ds.closeFile(); err != nil {
+	return cutTail(file, good)
+}
+
+// Corrupt tail may be only in the last segment, others were synced and sealed
+func (ds *diskSaver) replaySegment(seq uint64, last bool, fn func(rec *model.ShortURL)) (int64, error) {
+	file, err := os.OpenFile(segmentName(ds.walDir, seq), os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	good, err := readFrames(file, fn)
+	if !errors.Is(err, errCorruptRecord) {
+		return good, err
+	}
+	if !last {
+		return good, fmt.Errorf("%s at offset %d: %w", file.Name(), good, err)
+	}
+	return good, cutTail(file, good)
+}
+
+func cutTail(file *os.File, size int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	log.Printf("%s: corrupt tail of %d byte(s) cut off at offset %d", file.Name(), info.Size()-size, size)
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// Must be called with ds.mu locked
+func (ds *diskSaver) openSegment(seq uint64) error {
+	file, err := os.OpenFile(segmentName(ds.walDir, seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
 		return err
 	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	syncDir(ds.walDir)
+	ds.active, ds.seq, ds.segBytes, ds.dirty = file, seq, info.Size(), false
+	return nil
+}
+
+// Seals active segment and starts the next one, if active one is not empty.
+// Must be called with ds.mu locked.
+func (ds *diskSaver) rotate() error {
+	if ds.segBytes == 0 {
+		return nil
+	}
+	if ds.fsync != config.FsyncNever {
+		if err := ds.syncActive(); err != nil {
+			return err
+		}
+	}
+	if err := ds.active.Close(); err != nil {
+		return err
+	}
+	ds.active = nil
+	return ds.openSegment(ds.seq + 1)
+}
+
+// Must be called with ds.mu locked
+func (ds *diskSaver) syncActive() error {
+	if !ds.dirty {
+		return nil
+	}
+	if err := ds.active.Sync(); err != nil {
+		return err
+	}
+	ds.dirty = false
 	return nil
 }
 
-func (ds *diskSaver) openFile() error {
-	file, err := os.OpenFile(ds.filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
+func (ds *diskSaver) syncLoop(stop chan struct{}) {
+	ticker := time.NewTicker(ds.fsyncEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ds.mu.Lock()
+			if ds.active != nil {
+				if err := ds.syncActive(); err != nil {
+					log.Printf(" error syncing %s: %v\n", ds.active.Name(), err)
+				}
+			}
+			ds.mu.Unlock()
+		}
+	}
+}
+
+// Starts compaction in background if log is big enough and stale
+// copies take too much of storage. Must be called with ds.mu locked.
+func (ds *diskSaver) compactIfNeeded() {
+	if ds.minSize <= 0 || ds.ratio <= 0 || len(ds.keys) == 0 {
+		return
+	}
+	if float64(ds.lines) < ds.ratio*float64(len(ds.keys)) || ds.walBytes < ds.minSize {
+		return
+	}
+	if !ds.compacting.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer ds.compacting.Store(false)
+		if err := ds.compact(); err != nil {
+			log.Printf(" error compacting %s: %v\n", ds.filename, err)
+		}
+	}()
+}
+
+// Compacts storage on demand
+func (ds *diskSaver) Compact(ctx context.Context) error {
+	if !ds.compacting.CompareAndSwap(false, true) {
+		return nil
+	}
+	defer ds.compacting.Store(false)
+	return ds.compact()
+}
+
+func (ds *diskSaver) compact() error {
+	ds.mu.Lock()
+	err := ds.open()
+	if err == nil {
+		err = ds.rotate()
+	}
+	sealed := ds.seq - 1
+	compacted := ds.lines
+	ds.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// last copy of every record, in order of first appearance
+	order := make([]string, 0)
+	latest := make(map[string]*model.ShortURL)
+	collect := func(rec *model.ShortURL) {
+		if _, ok := latest[rec.Short]; !ok {
+			order = append(order, rec.Short)
+		}
+		latest[rec.Short] = rec
+	}
+	if err := readFile(ds.filename, readLines, collect); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	seqs, err := listSegments(ds.walDir)
 	if err != nil {
 		return err
 	}
-	ds.file = file
+	for len(seqs) > 0 && seqs[len(seqs)-1] > sealed {
+		seqs = seqs[:len(seqs)-1]
+	}
+	for _, seq := range seqs {
+		if err := readFile(segmentName(ds.walDir, seq), readFrames, collect); err != nil {
+			return err
+		}
+	}
+
+	tmpName := ds.filename + ".compact"
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+	defer tmp.Close()
+	encoder := json.NewEncoder(tmp)
+	for _, short := range order {
+		if err := encoder.Encode(latest[short]); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, ds.filename); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(ds.filename))
+
+	freed := int64(0)
+	for _, seq := range seqs {
+		name := segmentName(ds.walDir, seq)
+		if info, err := os.Stat(name); err == nil {
+			freed += info.Size()
+		}
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	syncDir(ds.walDir)
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	before := ds.lines
+	ds.lines += len(order) - compacted
+	ds.walBytes -= freed
+	log.Printf("%s compacted: %d -> %d record(s)", ds.filename, before, ds.lines)
 	return nil
 }
 
-func (ds *diskSaver) closeFile() error {
-	return ds.file.Close()
+func readFile(name string, read func(r io.Reader, fn func(rec *model.ShortURL)) (int64, error), fn func(rec *model.ShortURL)) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = read(file, fn)
+	return err
 }
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+)
+
+func testDiskConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		FileStorage: filepath.Join(t.TempDir(), "test.stor"),
+		Fsync:       config.FsyncNever,
+		WALSegment:  4 << 10,
+	}
+}
+
+func TestDiskSaver_Compact(t *testing.T) {
+	ctx := context.Background()
+	c := testDiskConfig(t)
+	ds := newDiskSaver(c)
+	defer ds.Close()
+
+	for i := 0; i < 10; i++ {
+		rec := model.ShortURL{Short: fmt.Sprintf("id%d", i), URL: fmt.Sprintf("http://%d.test", i)}
+		require.NoError(t, ds.Save(ctx, rec))
+		rec.Deleted = true
+		require.NoError(t, ds.UpdateBatch(ctx, []*model.ShortURL{&rec}))
+	}
+	require.Equal(t, 20, ds.lines)
+
+	// writes keep going while compacting
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 10; i < 20; i++ {
+			rec := model.ShortURL{Short: fmt.Sprintf("id%d", i), URL: fmt.Sprintf("http://%d.test", i)}
+			assert.NoError(t, ds.Save(ctx, rec))
+		}
+	}()
+	require.NoError(t, ds.Compact(ctx))
+	wg.Wait()
+
+	data := make(map[string]*model.ShortURL)
+	loaded := newDiskSaver(c)
+	require.NoError(t, loaded.Load(ctx, data))
+	loaded.Close()
+	assert.Len(t, data, 20)
+	for i := 0; i < 20; i++ {
+		rec, ok := data[fmt.Sprintf("id%d", i)]
+		require.True(t, ok)
+		assert.Equal(t, i < 10, rec.Deleted)
+	}
+
+	require.NoError(t, ds.Compact(ctx))
+	assert.Equal(t, 20, ds.lines)
+}
+
+func TestDiskSaver_AutoCompact(t *testing.T) {
+	ctx := context.Background()
+	c := testDiskConfig(t)
+	c.CompactSize, c.CompactRatio = 1, 2
+	ds := newDiskSaver(c)
+	defer ds.Close()
+
+	rec := model.ShortURL{Short: "id", URL: "http://test"}
+	for i := 0; i < 50; i++ {
+		require.NoError(t, ds.Save(ctx, rec))
+	}
+	require.Eventually(t, func() bool {
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+		return !ds.compacting.Load() && ds.lines < 50
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestDiskSaver_Recover(t *testing.T) {
+	ctx := context.Background()
+	c := testDiskConfig(t)
+	c.Fsync = config.FsyncAlways
+
+	// snapshot from older version with torn last line
+	legacy := `{"Short":"old","URL":"http://old.test"}` + "\n" + `{"Short":"torn","UR`
+	require.NoError(t, os.WriteFile(c.FileStorage, []byte(legacy), 0666))
+
+	ds := newDiskSaver(c)
+	for i := 0; i < 100; i++ {
+		rec := model.ShortURL{Short: fmt.Sprintf("id%d", i), URL: fmt.Sprintf("http://%d.test", i)}
+		require.NoError(t, ds.Save(ctx, rec))
+	}
+	require.NoError(t, ds.Close())
+	seqs, err := listSegments(c.FileStorage + segmentExt)
+	require.NoError(t, err)
+	require.Greater(t, len(seqs), 1, "log must be rotated")
+
+	// crash in the middle of write: half of frame and garbage
+	last := segmentName(c.FileStorage+segmentExt, seqs[len(seqs)-1])
+	good, err := os.Stat(last)
+	require.NoError(t, err)
+	frame, err := appendFrame(nil, &model.ShortURL{Short: "lost", URL: "http://lost.test"})
+	require.NoError(t, err)
+	file, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.Write(frame[:len(frame)/2])
+	require.NoError(t, err)
+	file.Close()
+
+	ds = newDiskSaver(c)
+	data := make(map[string]*model.ShortURL)
+	require.NoError(t, ds.Load(ctx, data))
+	assert.Len(t, data, 101)
+	assert.Contains(t, data, "old")
+	assert.NotContains(t, data, "torn")
+	assert.NotContains(t, data, "lost")
+	cut, err := os.Stat(last)
+	require.NoError(t, err)
+	assert.Equal(t, good.Size(), cut.Size())
+
+	// log goes on after recovery
+	require.NoError(t, ds.Save(ctx, model.ShortURL{Short: "new", URL: "http://new.test"}))
+	require.NoError(t, ds.Close())
+	data = make(map[string]*model.ShortURL)
+	ds = newDiskSaver(c)
+	require.NoError(t, ds.Load(ctx, data))
+	ds.Close()
+	assert.Len(t, data, 102)
+
+	// damaged sealed segment is not a crash, so it is an error
+	first := segmentName(c.FileStorage+segmentExt, seqs[0])
+	content, err := os.ReadFile(first)
+	require.NoError(t, err)
+	content[frameHeader+1] ^= 0xff
+	require.NoError(t, os.WriteFile(first, content, 0666))
+	assert.ErrorIs(t, newDiskSaver(c).Load(ctx, make(map[string]*model.ShortURL)), errCorruptRecord)
+}
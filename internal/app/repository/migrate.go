@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+)
+
+// Schema of postgres storage is changed by versioned migrations embedded
+// into binary: migrations/NNNN_name.up.sql and NNNN_name.down.sql.
+// Applied versions are kept in shrtnr_schema_version, every migration runs
+// in its own transaction together with the change of version, with table
+// lock so concurrent instances do not apply the same migration twice.
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+const createVersionTableSQL = "CREATE TABLE IF NOT EXISTS shrtnr_schema_version (version INTEGER PRIMARY KEY, name TEXT, applied_at TIMESTAMPTZ NOT NULL DEFAULT now());"
+const lockVersionSQL = "LOCK TABLE shrtnr_schema_version IN EXCLUSIVE MODE;"
+const selectVersionSQL = "SELECT COALESCE(MAX(version), 0) FROM shrtnr_schema_version;"
+const insertVersionSQL = "INSERT INTO shrtnr_schema_version (version, name) VALUES ($1, $2);"
+const deleteVersionSQL = "DELETE FROM shrtnr_schema_version WHERE version = $1;"
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// Reads embedded migrations ordered by version
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*migration)
+	for _, file := range files {
+		base := strings.TrimPrefix(file, "migrations/")
+		numStr, rest, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("bad migration file name %s", base)
+		}
+		version, err := strconv.Atoi(numStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration file name %s", base)
+		}
+		name, direction, ok := strings.Cut(strings.TrimSuffix(rest, ".sql"), ".")
+		if !ok {
+			return nil, fmt.Errorf("bad migration file name %s", base)
+		}
+		buf, err := migrationFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+		switch direction {
+		case "up":
+			m.up = string(buf)
+		case "down":
+			m.down = string(buf)
+		default:
+			return nil, fmt.Errorf("bad migration file name %s", base)
+		}
+	}
+
+	res := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.version, m.name)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].version < res[j].version
+	})
+	for ik, m := range res {
+		if m.version != ik+1 {
+			return nil, fmt.Errorf("migration %d is missing", ik+1)
+		}
+	}
+	return res, nil
+}
+
+// Returns current schema version and number of known migrations
+func (pg *pgSaver) schemaVersion(ctx context.Context) (int, int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := pg.pool.Exec(ctx, createVersionTableSQL); err != nil {
+		return 0, 0, err
+	}
+	version := 0
+	if err := pg.pool.QueryRow(ctx, selectVersionSQL).Scan(&version); err != nil {
+		return 0, 0, err
+	}
+	return version, len(migrations), nil
+}
+
+// Applies (steps > 0) or rolls back (steps < 0) given number
+// of migrations, steps == 0 means apply all new ones.
+func (pg *pgSaver) migrate(ctx context.Context, steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if _, err := pg.pool.Exec(ctx, createVersionTableSQL); err != nil {
+		return err
+	}
+	if steps == 0 {
+		steps = len(migrations)
+	}
+	for ; steps != 0; steps = towardsZero(steps) {
+		done, err := pg.migrateStep(ctx, migrations, steps > 0)
+		if err != nil {
+			return err
+		}
+		if !done {
+			break
+		}
+	}
+	return nil
+}
+
+func towardsZero(n int) int {
+	if n > 0 {
+		return n - 1
+	}
+	return n + 1
+}
+
+// Applies next migration or rolls back current one,
+// returns false if there is nothing to do.
+func (pg *pgSaver) migrateStep(ctx context.Context, migrations []migration, up bool) (bool, error) {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, lockVersionSQL); err != nil {
+		return false, err
+	}
+	version := 0
+	if err := tx.QueryRow(ctx, selectVersionSQL).Scan(&version); err != nil {
+		return false, err
+	}
+
+	var m migration
+	switch {
+	case up && version < len(migrations):
+		m = migrations[version]
+		if _, err := tx.Exec(ctx, m.up); err != nil {
+			return false, fmt.Errorf("migration %04d_%s up: %w", m.version, m.name, err)
+		}
+		if _, err := tx.Exec(ctx, insertVersionSQL, m.version, m.name); err != nil {
+			return false, err
+		}
+	case !up && version > 0 && version <= len(migrations):
+		m = migrations[version-1]
+		if _, err := tx.Exec(ctx, m.down); err != nil {
+			return false, fmt.Errorf("migration %04d_%s down: %w", m.version, m.name, err)
+		}
+		if _, err := tx.Exec(ctx, deleteVersionSQL, m.version); err != nil {
+			return false, err
+		}
+	case !up && version > len(migrations):
+		return false, fmt.Errorf("schema version %d is newer than this binary knows", version)
+	default:
+		return false, nil
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	direction := "applied"
+	if !up {
+		direction = "rolled back"
+	}
+	log.Printf("migration %04d_%s %s", m.version, m.name, direction)
+	return true, nil
+}
+
+// Runs migrate subcommand: "up [N]", "down [N]" or "status".
+// Up without N applies all new migrations, down without N rolls back one.
+func Migrate(ctx context.Context, c *config.Config, args []string) error {
+	if c.PgConnString == "" {
+		return fmt.Errorf("migrations need postgres, set DATABASE_DSN or -d")
+	}
+	pg := newPgSaver(c.PgConnString)
+	if err := pg.connect(); err != nil {
+		return err
+	}
+	defer pg.pool.Close()
+
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+	steps := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("number of migrations must be positive, got %q", args[1])
+		}
+		steps = n
+	}
+
+	switch args[0] {
+	case "up":
+		if err := pg.migrate(ctx, steps); err != nil {
+			return err
+		}
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		if err := pg.migrate(ctx, -steps); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return fmt.Errorf("unknown migrate command %q, use up, down or status", args[0])
+	}
+
+	version, total, err := pg.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("schema version %d of %d", version, total)
+	return nil
+}
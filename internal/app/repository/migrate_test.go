@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for ik, m := range migrations {
+		assert.Equal(t, ik+1, m.version)
+		assert.NotEmpty(t, m.name)
+		assert.NotEmpty(t, m.up)
+		assert.NotEmpty(t, m.down)
+	}
+	assert.Contains(t, migrations[1].up, "TYPE TEXT")
+	assert.Contains(t, migrations[3].up, "SET deleted = true", "duplicates are dropped before unique index")
+}
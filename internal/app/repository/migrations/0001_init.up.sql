@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS shrtnr_pair (short VARCHAR(20) PRIMARY KEY, url VARCHAR(80), userid CHAR(32), deleted BOOLEAN);
@@ -0,0 +1,2 @@
+-- fails if some url is longer than 80 chars
+ALTER TABLE shrtnr_pair ALTER COLUMN url TYPE VARCHAR(80);
@@ -0,0 +1 @@
+ALTER TABLE shrtnr_pair ALTER COLUMN url TYPE TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE shrtnr_pair DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE shrtnr_pair DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE shrtnr_pair DROP COLUMN IF EXISTS created_at;
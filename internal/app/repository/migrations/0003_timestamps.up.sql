@@ -0,0 +1,3 @@
+ALTER TABLE shrtnr_pair ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE shrtnr_pair ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE shrtnr_pair ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
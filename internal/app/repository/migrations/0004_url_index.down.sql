@@ -0,0 +1,2 @@
+-- duplicates marked deleted by up migration are left deleted
+DROP INDEX IF EXISTS shrtnr_pair_url_idx;
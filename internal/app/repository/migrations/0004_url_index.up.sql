@@ -0,0 +1,7 @@
+-- url shortened more than once before the index: newest short id is kept, older ones are marked deleted
+UPDATE shrtnr_pair p SET deleted = true, updated_at = now()
+WHERE NOT p.deleted AND EXISTS (
+    SELECT 1 FROM shrtnr_pair n
+    WHERE NOT n.deleted AND lower(n.url) = lower(p.url) AND (n.created_at, n.short) > (p.created_at, p.short)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS shrtnr_pair_url_idx ON shrtnr_pair (lower(url)) WHERE NOT deleted;
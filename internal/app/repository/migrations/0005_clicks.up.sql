@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS shrtnr_click (short VARCHAR(20), ts TIMESTAMPTZ, referrer TEXT, user_agent TEXT, ip TEXT);
+CREATE INDEX IF NOT EXISTS shrtnr_click_short_idx ON shrtnr_click (short, ts);
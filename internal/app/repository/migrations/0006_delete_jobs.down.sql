@@ -0,0 +1 @@
+DROP TABLE IF EXISTS shrtnr_delete_job;
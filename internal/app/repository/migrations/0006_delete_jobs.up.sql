@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS shrtnr_delete_job (id VARCHAR(32) PRIMARY KEY, userid TEXT, job JSONB NOT NULL, done BOOLEAN NOT NULL DEFAULT false, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), updated_at TIMESTAMPTZ NOT NULL DEFAULT now());
+CREATE INDEX IF NOT EXISTS shrtnr_delete_job_pending_idx ON shrtnr_delete_job (created_at) WHERE NOT done;
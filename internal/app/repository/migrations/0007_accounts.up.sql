@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS shrtnr_account (id TEXT PRIMARY KEY, email TEXT NOT NULL, password_hash TEXT NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now());
+CREATE UNIQUE INDEX IF NOT EXISTS shrtnr_account_email_idx ON shrtnr_account (lower(email));
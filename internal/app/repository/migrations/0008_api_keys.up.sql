@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS shrtnr_api_key (id TEXT PRIMARY KEY, userid TEXT NOT NULL, name TEXT NOT NULL, prefix TEXT NOT NULL, hash TEXT NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now(), last_used_at TIMESTAMPTZ, revoked_at TIMESTAMPTZ);
+CREATE UNIQUE INDEX IF NOT EXISTS shrtnr_api_key_hash_idx ON shrtnr_api_key (hash);
+CREATE INDEX IF NOT EXISTS shrtnr_api_key_userid_idx ON shrtnr_api_key (userid);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS shrtnr_audit;
+DROP TABLE IF EXISTS shrtnr_admin;
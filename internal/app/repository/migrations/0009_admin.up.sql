@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS shrtnr_admin (userid TEXT PRIMARY KEY, granted_at TIMESTAMPTZ NOT NULL DEFAULT now());
+CREATE TABLE IF NOT EXISTS shrtnr_audit (id BIGSERIAL PRIMARY KEY, at TIMESTAMPTZ NOT NULL, admin TEXT NOT NULL, action TEXT NOT NULL, short TEXT NOT NULL DEFAULT '', userid TEXT NOT NULL DEFAULT '', details TEXT NOT NULL DEFAULT '');
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS shrtnr_secret (name TEXT PRIMARY KEY, value TEXT NOT NULL, created_at TIMESTAMPTZ NOT NULL DEFAULT now());
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Point lookups for lazy mode, where postgres is the source of truth
+// and service does not keep all records in memory.
+
+const selectByShortSQL = "SELECT short, url, userid, deleted, expires_at FROM shrtnr_pair WHERE short = $1;"
+const selectByURLSQL = "SELECT short FROM shrtnr_pair WHERE lower(url) = lower($1) AND NOT deleted;"
+const selectByUserSQL = "SELECT short, url, userid, deleted, expires_at FROM shrtnr_pair WHERE userid = $1;"
+const selectExpiredSQL = "SELECT short, url, userid, deleted, expires_at FROM shrtnr_pair WHERE NOT deleted AND expires_at <= $1;"
+const searchSQL = `SELECT short, url, userid, deleted, expires_at FROM shrtnr_pair
+WHERE ($1 = '' OR short = $1 OR url ILIKE '%' || $2 || '%') AND ($3 = '' OR userid = $3) AND ($4::boolean IS NULL OR deleted = $4)
+ORDER BY short LIMIT $5 OFFSET $6;`
+const urlStatsSQL = "SELECT count(*), count(DISTINCT userid), count(*) FILTER (WHERE deleted) FROM shrtnr_pair;"
+const countSQL = "SELECT count(*) FROM shrtnr_pair;"
+
+const uniqueViolation = "23505"
+const urlIndexName = "shrtnr_pair_url_idx"
+
+type lookupRepository interface {
+	Get(ctx context.Context, short string) (model.ShortURL, error)
+	FindByURL(ctx context.Context, URL string) (string, error)
+	ListByUser(ctx context.Context, userID string) ([]model.ShortURL, error)
+	ListExpired(ctx context.Context, now time.Time) ([]model.ShortURL, error)
+	Search(ctx context.Context, filter model.URLFilter) ([]model.ShortURL, error)
+	Count(ctx context.Context) (int, error)
+	URLStats(ctx context.Context) (model.InternalStats, error)
+}
+
+func (pg *pgSaver) Get(ctx context.Context, short string) (model.ShortURL, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return model.ShortURL{}, err
+	}
+	rec := model.ShortURL{}
+	err = pool.QueryRow(ctx, selectByShortSQL, short).Scan(&rec.Short, &rec.URL, &rec.UserID, &rec.Deleted, &rec.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return rec, config.ErrNoSuchRecord
+	}
+	return rec, err
+}
+
+func (pg *pgSaver) FindByURL(ctx context.Context, URL string) (string, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return "", err
+	}
+	short := ""
+	err = pool.QueryRow(ctx, selectByURLSQL, URL).Scan(&short)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", config.ErrNoSuchRecord
+	}
+	return short, err
+}
+
+func (pg *pgSaver) ListByUser(ctx context.Context, userID string) ([]model.ShortURL, error) {
+	return pg.list(ctx, selectByUserSQL, userID)
+}
+
+func (pg *pgSaver) ListExpired(ctx context.Context, now time.Time) ([]model.ShortURL, error) {
+	return pg.list(ctx, selectExpiredSQL, now)
+}
+
+// Escapes wildcards of LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (pg *pgSaver) Search(ctx context.Context, filter model.URLFilter) ([]model.ShortURL, error) {
+	return pg.list(ctx, searchSQL, filter.Query, likeEscaper.Replace(filter.Query), filter.UserID,
+		filter.Deleted, filter.Limit, filter.Offset)
+}
+
+func (pg *pgSaver) Count(ctx context.Context) (int, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return 0, err
+	}
+	res := 0
+	err = pool.QueryRow(ctx, countSQL).Scan(&res)
+	return res, err
+}
+
+// Fills counts of urls, users and deleted urls
+func (pg *pgSaver) URLStats(ctx context.Context) (model.InternalStats, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return model.InternalStats{}, err
+	}
+	res := model.InternalStats{}
+	err = pool.QueryRow(ctx, urlStatsSQL).Scan(&res.URLs, &res.Users, &res.Deleted)
+	return res, err
+}
+
+func (pg *pgSaver) list(ctx context.Context, sql string, args ...any) ([]model.ShortURL, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]model.ShortURL, 0)
+	for rows.Next() {
+		rec := model.ShortURL{}
+		if err := rows.Scan(&rec.Short, &rec.URL, &rec.UserID, &rec.Deleted, &rec.ExpiresAt); err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+	return res, rows.Err()
+}
+
+// Turns violation of unique url index into ErrDuplicateURL
+func wrapPgError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == urlIndexName {
+		return fmt.Errorf("%w: %v", config.ErrDuplicateURL, err)
+	}
+	return err
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"sync"
 
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
 	"github.com/jackc/pgx/v5"
@@ -10,13 +11,15 @@ import (
 
 type pgSaver struct {
 	connString string
+	mu         sync.Mutex
 	pool       *pgxpool.Pool
 }
 
-const createTableSQL = "CREATE TABLE IF NOT EXISTS shrtnr_pair (short VARCHAR(20) PRIMARY KEY, url VARCHAR(80), userid CHAR(32), deleted BOOLEAN);"
-const selectSQL = "SELECT short, url, userid, deleted FROM shrtnr_pair;"
-const insertSQL = "INSERT INTO shrtnr_pair (short, url, userid, deleted) VALUES ($1, $2, $3, $4);"
-const updateSQL = "UPDATE shrtnr_pair SET url = $2, userid = $3, deleted = $4 WHERE short = $1;"
+const clickStatsSQL = "SELECT date_trunc($2, ts), count(*) FROM shrtnr_click WHERE short = $1 GROUP BY 1 ORDER BY 1;"
+const clickCountSQL = "SELECT count(*) FROM shrtnr_click;"
+const selectSQL = "SELECT short, url, userid, deleted, expires_at FROM shrtnr_pair;"
+const insertSQL = "INSERT INTO shrtnr_pair (short, url, userid, deleted, expires_at) VALUES ($1, $2, $3, $4, $5);"
+const updateSQL = "UPDATE shrtnr_pair SET url = $2, userid = $3, deleted = $4, expires_at = $5, updated_at = now() WHERE short = $1;"
 
 func newPgSaver(conn string) *pgSaver {
 	if conn == "" {
@@ -27,33 +30,48 @@ func newPgSaver(conn string) *pgSaver {
 	}
 }
 
+// Connects to db and brings its schema up to date
 func (pg *pgSaver) createPool() error {
-	pgxConnConfig, err := pgxpool.ParseConfig(pg.connString)
-	if err != nil {
+	if err := pg.connect(); err != nil {
 		return err
 	}
-	pg.pool, err = pgxpool.NewWithConfig(context.Background(), pgxConnConfig)
-	if err != nil {
+	if err := pg.migrate(context.Background(), 0); err != nil {
+		pg.pool.Close()
+		pg.pool = nil
 		return err
 	}
-	return pg.prepareTable()
+	return nil
+}
+
+// Every entry point gets pool here, so db not reachable at start is
+// connected on first call that needs it. Pool is kept only when schema
+// is migrated, next call tries again otherwise.
+func (pg *pgSaver) ensurePool() (*pgxpool.Pool, error) {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	if pg.pool == nil {
+		if err := pg.createPool(); err != nil {
+			return nil, err
+		}
+	}
+	return pg.pool, nil
 }
 
-func (pg *pgSaver) prepareTable() error {
-	_, err := pg.pool.Exec(context.Background(), createTableSQL)
+func (pg *pgSaver) connect() error {
+	pgxConnConfig, err := pgxpool.ParseConfig(pg.connString)
 	if err != nil {
 		return err
 	}
-	return nil
+	pg.pool, err = pgxpool.NewWithConfig(context.Background(), pgxConnConfig)
+	return err
 }
 
 func (pg *pgSaver) Load(ctx context.Context, data map[string]*model.ShortURL) error {
-	if pg.pool == nil {
-		if err := pg.createPool(); err != nil {
-			return err
-		}
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
 	}
-	rows, err := pg.pool.Query(ctx, selectSQL)
+	rows, err := pool.Query(ctx, selectSQL)
 	if err != nil {
 		return err
 	}
@@ -61,7 +79,7 @@ func (pg *pgSaver) Load(ctx context.Context, data map[string]*model.ShortURL) er
 
 	for rows.Next() {
 		shortRec := &model.ShortURL{}
-		err := rows.Scan(&shortRec.Short, &shortRec.URL, &shortRec.UserID, &shortRec.Deleted)
+		err := rows.Scan(&shortRec.Short, &shortRec.URL, &shortRec.UserID, &shortRec.Deleted, &shortRec.ExpiresAt)
 		if err != nil {
 			return err
 		}
@@ -71,10 +89,14 @@ func (pg *pgSaver) Load(ctx context.Context, data map[string]*model.ShortURL) er
 }
 
 func (pg *pgSaver) Save(ctx context.Context, data model.ShortURL) error {
-	_, err := pg.pool.Exec(ctx, insertSQL, data.Short, data.URL, data.UserID, data.Deleted)
+	pool, err := pg.ensurePool()
 	if err != nil {
 		return err
 	}
+	_, err = pool.Exec(ctx, insertSQL, data.Short, data.URL, data.UserID, data.Deleted, data.ExpiresAt)
+	if err != nil {
+		return wrapPgError(err)
+	}
 	return nil
 }
 
@@ -86,20 +108,90 @@ func (pg *pgSaver) SaveBatch(ctx context.Context, data []*model.ShortURL) error
 	return pg.batchUpsert(ctx, insertSQL, data)
 }
 
+func (pg *pgSaver) SaveClicks(ctx context.Context, data []model.Click) error {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
+	}
+	_, err = pool.CopyFrom(ctx,
+		pgx.Identifier{"shrtnr_click"},
+		[]string{"short", "ts", "referrer", "user_agent", "ip"},
+		pgx.CopyFromSlice(len(data), func(ik int) ([]any, error) {
+			return []any{data[ik].Short, data[ik].Time, data[ik].Referrer, data[ik].UserAgent, data[ik].IP}, nil
+		}),
+	)
+	return err
+}
+
+func (pg *pgSaver) ClickStats(ctx context.Context, short string, bucket string) (model.ClickStats, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return model.ClickStats{}, err
+	}
+	res := model.ClickStats{
+		ShortURL: short,
+		Bucket:   bucket,
+		Buckets:  make([]model.ClickBucket, 0),
+	}
+	rows, err := pool.Query(ctx, clickStatsSQL, short, bucket)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rec := model.ClickBucket{}
+		if err := rows.Scan(&rec.Start, &rec.Count); err != nil {
+			return res, err
+		}
+		rec.Start = rec.Start.UTC()
+		res.Total += rec.Count
+		res.Buckets = append(res.Buckets, rec)
+	}
+	return res, rows.Err()
+}
+
+func (pg *pgSaver) ClickCount(ctx context.Context) (int, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return 0, err
+	}
+	res := 0
+	err = pool.QueryRow(ctx, clickCountSQL).Scan(&res)
+	return res, err
+}
+
 func (pg *pgSaver) Ping(ctx context.Context) error {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
+	}
 	pctx := ctx
 	if ctx == nil {
 		pctx = context.Background()
 	}
-	err := pg.pool.Ping(pctx)
+	err = pool.Ping(pctx)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (pg *pgSaver) Close() error {
+	pg.mu.Lock()
+	defer pg.mu.Unlock()
+	if pg.pool != nil {
+		pg.pool.Close()
+	}
+	return nil
+}
+
 func (pg *pgSaver) batchUpsert(ctx context.Context, sqlStatement string, data []*model.ShortURL) error {
-	tx, err := pg.pool.Begin(ctx)
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return err
+	}
+	tx, err := pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
@@ -108,14 +200,14 @@ func (pg *pgSaver) batchUpsert(ctx context.Context, sqlStatement string, data []
 	btch := &pgx.Batch{}
 
 	for _, rec := range data {
-		btch.Queue(sqlStatement, rec.Short, rec.URL, rec.UserID, rec.Deleted)
+		btch.Queue(sqlStatement, rec.Short, rec.URL, rec.UserID, rec.Deleted, rec.ExpiresAt)
 	}
 	bres := tx.SendBatch(ctx, btch)
 
 	for range data {
 		_, qerr := bres.Exec()
 		if qerr != nil {
-			return qerr
+			return wrapPgError(qerr)
 		}
 	}
 	err = bres.Close()
@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgSaver_NotReachable(t *testing.T) {
+	pg := newPgSaver("postgres://user@127.0.0.1:1/shortener?connect_timeout=1")
+	ctx := context.Background()
+
+	// every entry point reports error instead of using nil pool
+	_, err := pg.Get(ctx, "abcde")
+	assert.Error(t, err)
+	_, err = pg.FindByURL(ctx, "http://ya.ru")
+	assert.Error(t, err)
+	_, err = pg.ListByUser(ctx, "user1")
+	assert.Error(t, err)
+	_, err = pg.URLStats(ctx)
+	assert.Error(t, err)
+	_, err = pg.AccountByID(ctx, "user1")
+	assert.Error(t, err)
+	_, err = pg.IsAdmin(ctx, "user1")
+	assert.Error(t, err)
+	assert.Error(t, pg.Ping(ctx))
+	assert.Nil(t, pg.pool, "pool of not migrated db is not kept")
+	assert.NoError(t, pg.Close())
+}
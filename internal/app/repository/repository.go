@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
@@ -15,21 +17,48 @@ type mediaRepository interface {
 	Ping(ctx context.Context) error
 }
 
+var ErrLookupNotSupported = errors.New("storage can't look up single records, postgres is needed")
+
 type Repository struct {
 	ms mediaRepository
+	cs clickRepository
+	dq deleteRepository
+	as accountRepository
+	ks apiKeyRepository
+	ad adminRepository
+	ss secretRepository
 }
 
 func New(c *config.Config) *Repository {
 	ms := mediaRepository(nil)
+	cs := clickRepository(newMemClickSaver())
+	dq := deleteRepository(newMemDeleteQueue())
+	as := accountRepository(newMemAccounts())
+	ks := apiKeyRepository(newMemAPIKeys())
+	ad := adminRepository(newMemAdmins())
+	ss := secretRepository(newMemSecrets())
 	if c.PgConnString != "" {
-		ms = newPgSaver(c.PgConnString)
+		pg := newPgSaver(c.PgConnString)
+		ms, cs, dq, as, ks, ad, ss = pg, pg, pg, pg, pg, pg, pg
 	} else {
 		if c.FileStorage != "" {
-			ms = newDiskSaver(c.FileStorage)
+			ms = newDiskSaver(c)
+			cs = newFileClickSaver(c.FileStorage)
+			dq = newFileDeleteQueue(c.FileStorage)
+			as = newFileAccounts(c.FileStorage)
+			ks = newFileAPIKeys(c.FileStorage)
+			ad = newFileAdmins(c.FileStorage)
+			ss = newFileSecrets(c.FileStorage)
 		}
 	}
 	return &Repository{
 		ms: ms,
+		cs: cs,
+		dq: dq,
+		as: as,
+		ks: ks,
+		ad: ad,
+		ss: ss,
 	}
 }
 
@@ -67,3 +96,155 @@ func (s *Repository) Ping(ctx context.Context) error {
 	}
 	return nil
 }
+
+func (s *Repository) SaveClicks(ctx context.Context, data []model.Click) error {
+	return s.cs.SaveClicks(ctx, data)
+}
+
+func (s *Repository) ClickStats(ctx context.Context, short string, bucket string) (model.ClickStats, error) {
+	return s.cs.ClickStats(ctx, short, bucket)
+}
+
+func (s *Repository) ClickCount(ctx context.Context) (int, error) {
+	return s.cs.ClickCount(ctx)
+}
+
+func (s *Repository) SaveDeleteJob(ctx context.Context, job model.DeleteJob) error {
+	return s.dq.SaveDeleteJob(ctx, job)
+}
+
+func (s *Repository) GetDeleteJob(ctx context.Context, id string) (model.DeleteJob, error) {
+	return s.dq.GetDeleteJob(ctx, id)
+}
+
+func (s *Repository) PendingDeleteJobs(ctx context.Context) ([]model.DeleteJob, error) {
+	return s.dq.PendingDeleteJobs(ctx)
+}
+
+func (s *Repository) SaveAccount(ctx context.Context, acc model.Account) error {
+	return s.as.SaveAccount(ctx, acc)
+}
+
+func (s *Repository) AccountByEmail(ctx context.Context, email string) (model.Account, error) {
+	return s.as.AccountByEmail(ctx, email)
+}
+
+func (s *Repository) AccountByID(ctx context.Context, id string) (model.Account, error) {
+	return s.as.AccountByID(ctx, id)
+}
+
+func (s *Repository) SaveAPIKey(ctx context.Context, key model.APIKey) error {
+	return s.ks.SaveAPIKey(ctx, key)
+}
+
+func (s *Repository) APIKeyByHash(ctx context.Context, hash string) (model.APIKey, error) {
+	return s.ks.APIKeyByHash(ctx, hash)
+}
+
+func (s *Repository) APIKeysByUser(ctx context.Context, userID string) ([]model.APIKey, error) {
+	return s.ks.APIKeysByUser(ctx, userID)
+}
+
+func (s *Repository) TouchAPIKey(ctx context.Context, id string, used time.Time) error {
+	return s.ks.TouchAPIKey(ctx, id, used)
+}
+
+func (s *Repository) SetAdmin(ctx context.Context, userID string, admin bool) error {
+	return s.ad.SetAdmin(ctx, userID, admin)
+}
+
+func (s *Repository) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	return s.ad.IsAdmin(ctx, userID)
+}
+
+func (s *Repository) SaveAudit(ctx context.Context, entry model.AuditEntry) error {
+	return s.ad.SaveAudit(ctx, entry)
+}
+
+func (s *Repository) AuditLog(ctx context.Context, limit int) ([]model.AuditEntry, error) {
+	return s.ad.AuditLog(ctx, limit)
+}
+
+func (s *Repository) Secret(ctx context.Context, name, candidate string) (string, error) {
+	return s.ss.Secret(ctx, name, candidate)
+}
+
+func (s *Repository) lookup() (lookupRepository, error) {
+	if lr, ok := s.ms.(lookupRepository); ok {
+		return lr, nil
+	}
+	return nil, ErrLookupNotSupported
+}
+
+func (s *Repository) Get(ctx context.Context, short string) (model.ShortURL, error) {
+	lr, err := s.lookup()
+	if err != nil {
+		return model.ShortURL{}, err
+	}
+	return lr.Get(ctx, short)
+}
+
+func (s *Repository) FindByURL(ctx context.Context, URL string) (string, error) {
+	lr, err := s.lookup()
+	if err != nil {
+		return "", err
+	}
+	return lr.FindByURL(ctx, URL)
+}
+
+func (s *Repository) ListByUser(ctx context.Context, userID string) ([]model.ShortURL, error) {
+	lr, err := s.lookup()
+	if err != nil {
+		return nil, err
+	}
+	return lr.ListByUser(ctx, userID)
+}
+
+func (s *Repository) ListExpired(ctx context.Context, now time.Time) ([]model.ShortURL, error) {
+	lr, err := s.lookup()
+	if err != nil {
+		return nil, err
+	}
+	return lr.ListExpired(ctx, now)
+}
+
+func (s *Repository) Search(ctx context.Context, filter model.URLFilter) ([]model.ShortURL, error) {
+	lr, err := s.lookup()
+	if err != nil {
+		return nil, err
+	}
+	return lr.Search(ctx, filter)
+}
+
+func (s *Repository) Count(ctx context.Context) (int, error) {
+	lr, err := s.lookup()
+	if err != nil {
+		return 0, err
+	}
+	return lr.Count(ctx)
+}
+
+func (s *Repository) URLStats(ctx context.Context) (model.InternalStats, error) {
+	lr, err := s.lookup()
+	if err != nil {
+		return model.InternalStats{}, err
+	}
+	return lr.URLStats(ctx)
+}
+
+// Closes records file or postgres pool, other
+// files are opened for every operation.
+func (s *Repository) Close() error {
+	if closer, ok := s.ms.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Compacts file storage, other storages do not need it
+func (s *Repository) Compact(ctx context.Context) error {
+	if ds, ok := s.ms.(*diskSaver); ok {
+		return ds.Compact(ctx)
+	}
+	return nil
+}
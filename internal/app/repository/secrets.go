@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// Secrets made by service itself, like cookie key when it is not given
+// by config. The first stored value of name wins, so every instance and
+// every restart gets the same one. Postgres keeps them in table, file
+// storage in file next to records file, memory only until restart.
+
+type secretRepository interface {
+	Secret(ctx context.Context, name, candidate string) (string, error)
+}
+
+const secretFileSuffix = ".secrets"
+
+const insertSecretSQL = "INSERT INTO shrtnr_secret (name, value) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING;"
+const selectSecretSQL = "SELECT value FROM shrtnr_secret WHERE name = $1;"
+
+// Stores candidate, if there is no value of name yet, and returns stored value
+func (pg *pgSaver) Secret(ctx context.Context, name, candidate string) (string, error) {
+	pool, err := pg.ensurePool()
+	if err != nil {
+		return "", err
+	}
+	if _, err := pool.Exec(ctx, insertSecretSQL, name, candidate); err != nil {
+		return "", err
+	}
+	value := ""
+	err = pool.QueryRow(ctx, selectSecretSQL, name).Scan(&value)
+	return value, err
+}
+
+type memSecrets struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func newMemSecrets() *memSecrets {
+	return &memSecrets{
+		secrets: make(map[string]string),
+	}
+}
+
+func (ms *memSecrets) Secret(ctx context.Context, name, candidate string) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if value, found := ms.secrets[name]; found {
+		return value, nil
+	}
+	ms.secrets[name] = candidate
+	return candidate, nil
+}
+
+type secretRecord struct {
+	Name  string `json:"NAME"`
+	Value string `json:"VALUE"`
+}
+
+type fileSecrets struct {
+	*memSecrets
+	filename string
+	once     sync.Once
+	loadErr  error
+}
+
+func newFileSecrets(filename string) *fileSecrets {
+	return &fileSecrets{
+		memSecrets: newMemSecrets(),
+		filename:   filename + secretFileSuffix,
+	}
+}
+
+func (fs *fileSecrets) Secret(ctx context.Context, name, candidate string) (string, error) {
+	if err := fs.load(); err != nil {
+		return "", err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if value, found := fs.secrets[name]; found {
+		return value, nil
+	}
+	if err := appendJSON(fs.filename, secretRecord{Name: name, Value: candidate}); err != nil {
+		return "", err
+	}
+	fs.secrets[name] = candidate
+	return candidate, nil
+}
+
+func (fs *fileSecrets) load() error {
+	fs.once.Do(func() {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		fs.loadErr = readJSONLines(fs.filename, func(decoder *json.Decoder) error {
+			rec := secretRecord{}
+			if err := decoder.Decode(&rec); err != nil {
+				return err
+			}
+			if _, found := fs.secrets[rec.Name]; !found {
+				fs.secrets[rec.Name] = rec.Value
+			}
+			return nil
+		})
+	})
+	return fs.loadErr
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+)
+
+// Write-ahead log of file storage. Records are appended to segment files
+// in <storage file>.wal directory, every record is framed as
+//
+//	payload length uint32 | crc32c of payload uint32 | payload (json of record)
+//
+// Segments are named by sequence number and replayed in that order on top
+// of snapshot, which is storage file itself in old json lines format.
+
+const (
+	frameHeader = 8
+	maxFrame    = 1 << 20
+	segmentExt  = ".wal"
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+	// torn or damaged record, all after it is cut off on recovery
+	errCorruptRecord = errors.New("corrupt record")
+)
+
+func appendFrame(buf []byte, rec *model.ShortURL) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return buf, err
+	}
+	var hdr [frameHeader]byte
+	binary.LittleEndian.PutUint32(hdr[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(hdr[4:], crc32.Checksum(payload, crcTable))
+	buf = append(buf, hdr[:]...)
+	return append(buf, payload...), nil
+}
+
+// Reads frames until EOF, returns offset of the end of last good frame
+func readFrames(r io.Reader, fn func(rec *model.ShortURL)) (int64, error) {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, frameHeader)
+	off := int64(0)
+	for {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			switch err {
+			case io.EOF:
+				return off, nil
+			case io.ErrUnexpectedEOF:
+				return off, errCorruptRecord
+			}
+			return off, err
+		}
+		size := binary.LittleEndian.Uint32(hdr[:4])
+		if size > maxFrame {
+			return off, errCorruptRecord
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return off, errCorruptRecord
+			}
+			return off, err
+		}
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(hdr[4:]) {
+			return off, errCorruptRecord
+		}
+		rec := &model.ShortURL{}
+		if err := json.Unmarshal(payload, rec); err != nil {
+			return off, errCorruptRecord
+		}
+		fn(rec)
+		off += frameHeader + int64(size)
+	}
+}
+
+// Reads json lines of snapshot. Only the last line not ended by newline
+// is torn write, damaged line in the middle is an error.
+func readLines(r io.Reader, fn func(rec *model.ShortURL)) (int64, error) {
+	br := bufio.NewReader(r)
+	off := int64(0)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return off, err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			rec := &model.ShortURL{}
+			if errJSON := json.Unmarshal(line, rec); errJSON != nil {
+				if err == io.EOF {
+					return off, errCorruptRecord
+				}
+				return off, fmt.Errorf("bad record at offset %d: %w", off, errJSON)
+			}
+			fn(rec)
+		}
+		off += int64(len(line))
+		if err == io.EOF {
+			return off, nil
+		}
+	}
+}
+
+// Returns sequence numbers of segments in dir in ascending order
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, seq)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+func segmentName(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%016d%s", seq, segmentExt))
+}
+
+// Makes creating, renaming and removing of files in dir durable
+func syncDir(dir string) {
+	file, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	file.Sync()
+	file.Close()
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+)
+
+// Account has its own user id. On register and login links of current
+// user go to account, if current user is anonymous (has no account),
+// so links made before login are not lost.
+
+const (
+	minPasswordLen = 8
+	maxPasswordLen = 72 // bcrypt uses only first 72 bytes
+)
+
+var bcryptCost = bcrypt.DefaultCost
+
+// Compared with when there is no such account, so response time
+// does not tell if email is registered
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("no such account"), bcryptCost)
+
+// Registers account and moves links of current user to it.
+// Returns user id of account and number of moved links.
+func (s *Service) Register(ctx context.Context, email, password string) (string, int, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != strings.TrimSpace(email) {
+		return "", 0, config.ErrAccountNotValid
+	}
+	if len(password) < minPasswordLen || len(password) > maxPasswordLen {
+		return "", 0, config.ErrAccountNotValid
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", 0, err
+	}
+	acc := model.Account{
+		ID:           newRandomID(),
+		Email:        addr.Address,
+		PasswordHash: string(hash),
+		Created:      time.Now().UTC(),
+	}
+	if err := s.ds.SaveAccount(ctx, acc); err != nil {
+		return "", 0, err
+	}
+	moved, err := s.adoptLinks(ctx, acc.ID)
+	return acc.ID, moved, err
+}
+
+// Checks password and moves links of current user to account.
+// Returns user id of account and number of moved links.
+func (s *Service) Login(ctx context.Context, email, password string) (string, int, error) {
+	acc, err := s.ds.AccountByEmail(ctx, strings.TrimSpace(email))
+	switch {
+	case errors.Is(err, config.ErrNoSuchRecord):
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", 0, config.ErrBadCredentials
+	case err != nil:
+		return "", 0, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(acc.PasswordHash), []byte(password)); err != nil {
+		return "", 0, config.ErrBadCredentials
+	}
+	moved, err := s.adoptLinks(ctx, acc.ID)
+	return acc.ID, moved, err
+}
+
+// Moves links of current user to account, if current user is anonymous
+func (s *Service) adoptLinks(ctx context.Context, accountID string) (int, error) {
+	userID, _ := ctx.Value(config.ContextKeyUserID).(string)
+	if userID == "" || userID == accountID {
+		return 0, nil
+	}
+	_, err := s.ds.AccountByID(ctx, userID)
+	switch {
+	case err == nil:
+		// logged in as another account, its links stay there
+		return 0, nil
+	case !errors.Is(err, config.ErrNoSuchRecord):
+		return 0, err
+	}
+
+	urls, err := s.urls.byUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	moved := make([]*model.ShortURL, 0, len(urls))
+	for _, url := range urls {
+		rec, err := s.urls.update(ctx, url.Short, func(rec *model.ShortURL) error {
+			if rec.UserID != userID {
+				return config.ErrNotOwner
+			}
+			rec.UserID = accountID
+			return nil
+		})
+		if err != nil {
+			continue
+		}
+		moved = append(moved, &rec)
+	}
+	if len(moved) == 0 {
+		return 0, nil
+	}
+	log.Printf("%d url(s) moved from user %s to account %s", len(moved), userID, accountID)
+	return len(moved), s.ds.UpdateBatch(ctx, moved)
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestService_Accounts(t *testing.T) {
+	bcryptCost = bcrypt.MinCost
+	c := &config.Config{LenShortURL: 5}
+	s := New(repository.New(c), c)
+	asUser := func(userID string) context.Context {
+		return context.WithValue(context.Background(), config.ContextKeyUserID, userID)
+	}
+
+	_, err := s.Post(asUser("anon1"), "http://first.ru", model.ShortOpts{})
+	require.NoError(t, err)
+	accountID, moved, err := s.Register(asUser("anon1"), "user@example.com", "password")
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+
+	_, _, err = s.Register(asUser("anon2"), "USER@example.com", "password")
+	assert.ErrorIs(t, err, config.ErrEmailTaken)
+	for _, bad := range [][2]string{{"not email", "password"}, {"other@example.com", "short"}} {
+		_, _, err = s.Register(asUser("anon2"), bad[0], bad[1])
+		assert.ErrorIs(t, err, config.ErrAccountNotValid)
+	}
+
+	// links made before login go to account
+	_, err = s.Post(asUser("anon2"), "http://second.ru", model.ShortOpts{})
+	require.NoError(t, err)
+	_, _, err = s.Login(asUser("anon2"), "user@example.com", "wrong password")
+	assert.ErrorIs(t, err, config.ErrBadCredentials)
+	_, _, err = s.Login(asUser("anon2"), "nobody@example.com", "password")
+	assert.ErrorIs(t, err, config.ErrBadCredentials)
+	loggedID, moved, err := s.Login(asUser("anon2"), "user@example.com", "password")
+	require.NoError(t, err)
+	assert.Equal(t, accountID, loggedID)
+	assert.Equal(t, 1, moved)
+
+	urls, err := s.GetURLByUser(context.Background(), accountID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+	urls, err = s.GetURLByUser(context.Background(), "anon2")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	// links of another account are not taken
+	otherID, _, err := s.Register(asUser("anon3"), "other@example.com", "password")
+	require.NoError(t, err)
+	_, err = s.Post(asUser(otherID), "http://third.ru", model.ShortOpts{})
+	require.NoError(t, err)
+	_, moved, err = s.Login(asUser(otherID), "user@example.com", "password")
+	require.NoError(t, err)
+	assert.Zero(t, moved)
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+)
+
+// Admin sees records of all users and may delete, restore and give them
+// to another user. Admin role comes from ADMIN_USERS or from repository
+// (see "admin" command). Every change is written to audit trail, user id
+// of admin is taken from context.
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+	defaultAuditLimit  = 100
+)
+
+func parseAdmins(list string) map[string]bool {
+	res := make(map[string]bool)
+	for _, userID := range strings.Split(list, ",") {
+		if userID = strings.TrimSpace(userID); userID != "" {
+			res[userID] = true
+		}
+	}
+	return res
+}
+
+func (s *Service) IsAdmin(ctx context.Context, userID string) (bool, error) {
+	if s.rt.Load().admins[userID] {
+		return true, nil
+	}
+	return s.ds.IsAdmin(ctx, userID)
+}
+
+func (s *Service) adminURL(rec model.ShortURL) model.AdminURL {
+	return model.AdminURL{
+		ID:        rec.Short,
+		ShortURL:  s.c.HostName + rec.Short,
+		URL:       rec.URL,
+		UserID:    rec.UserID,
+		Deleted:   rec.Deleted,
+		ExpiresAt: rec.ExpiresAt,
+	}
+}
+
+func (s *Service) AdminSearch(ctx context.Context, filter model.URLFilter) ([]model.AdminURL, error) {
+	switch {
+	case filter.Limit <= 0:
+		filter.Limit = defaultSearchLimit
+	case filter.Limit > maxSearchLimit:
+		filter.Limit = maxSearchLimit
+	}
+	found, err := s.urls.search(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]model.AdminURL, 0, len(found))
+	for _, rec := range found {
+		res = append(res, s.adminURL(rec))
+	}
+	return res, nil
+}
+
+// Deletes record of any user. Deleting deleted record is not an error,
+// it is not written to audit trail.
+func (s *Service) AdminDelete(ctx context.Context, short string) (model.AdminURL, error) {
+	rec := model.ShortURL{Short: short}
+	result, err := s.markDeleted(ctx, &rec, true)
+	switch {
+	case err != nil:
+		return model.AdminURL{}, err
+	case result == model.DeleteNotFound:
+		return model.AdminURL{}, config.ErrNoSuchRecord
+	case result == model.DeleteAlready:
+		return s.adminURL(rec), nil
+	}
+	if err := s.ds.UpdateBatch(ctx, []*model.ShortURL{&rec}); err != nil {
+		s.urls.update(ctx, short, func(rec *model.ShortURL) error {
+			rec.Deleted = false
+			return nil
+		})
+		return model.AdminURL{}, err
+	}
+	return s.adminURL(rec), s.audit(ctx, model.AuditDelete, short, rec.UserID, "")
+}
+
+// Brings deleted record back, unless its url has another short id by now
+func (s *Service) AdminRestore(ctx context.Context, short string) (model.AdminURL, error) {
+	rec, found, err := s.urls.get(ctx, short)
+	switch {
+	case err != nil:
+		return model.AdminURL{}, err
+	case !found:
+		return model.AdminURL{}, config.ErrNoSuchRecord
+	case !rec.Deleted:
+		return s.adminURL(rec), nil
+	}
+	other, found, err := s.urls.findShort(ctx, rec.URL)
+	if err != nil {
+		return model.AdminURL{}, err
+	}
+	if found && other != short {
+		return model.AdminURL{}, fmt.Errorf("%w: %s is shortened as %s", config.ErrDuplicateURL, rec.URL, other)
+	}
+
+	restored, err := s.urls.update(ctx, short, func(rec *model.ShortURL) error {
+		rec.Deleted = false
+		return nil
+	})
+	if err != nil {
+		return model.AdminURL{}, err
+	}
+	if err := s.ds.UpdateBatch(ctx, []*model.ShortURL{&restored}); err != nil {
+		s.urls.update(ctx, short, func(rec *model.ShortURL) error {
+			rec.Deleted = true
+			return nil
+		})
+		return model.AdminURL{}, err
+	}
+	return s.adminURL(restored), s.audit(ctx, model.AuditRestore, short, restored.UserID, "")
+}
+
+// Gives record to another user
+func (s *Service) AdminReassign(ctx context.Context, short, userID string) (model.AdminURL, error) {
+	if userID == "" {
+		return model.AdminURL{}, config.ErrInvalidReqBody
+	}
+	if !validUserID(userID) {
+		return model.AdminURL{}, config.ErrUserIDNotValid
+	}
+	oldUserID := ""
+	rec, err := s.urls.update(ctx, short, func(rec *model.ShortURL) error {
+		oldUserID = rec.UserID
+		rec.UserID = userID
+		return nil
+	})
+	if err != nil {
+		return model.AdminURL{}, err
+	}
+	if oldUserID == userID {
+		return s.adminURL(rec), nil
+	}
+	if err := s.ds.UpdateBatch(ctx, []*model.ShortURL{&rec}); err != nil {
+		s.urls.update(ctx, short, func(rec *model.ShortURL) error {
+			rec.UserID = oldUserID
+			return nil
+		})
+		return model.AdminURL{}, err
+	}
+	return s.adminURL(rec), s.audit(ctx, model.AuditReassign, short, userID, "from "+oldUserID)
+}
+
+// User ids and account ids are both hex of 16 random bytes, storage
+// keeps them as CHAR(32)
+func validUserID(userID string) bool {
+	if len(userID) != 32 {
+		return false
+	}
+	for _, ch := range userID {
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns last admin actions, newest first
+func (s *Service) AuditLog(ctx context.Context, limit int) ([]model.AuditEntry, error) {
+	if limit <= 0 || limit > maxSearchLimit {
+		limit = defaultAuditLimit
+	}
+	return s.ds.AuditLog(ctx, limit)
+}
+
+func (s *Service) audit(ctx context.Context, action, short, userID, details string) error {
+	admin := ctx.Value(config.ContextKeyUserID).(string)
+	entry := model.AuditEntry{
+		Time:    time.Now().UTC(),
+		Admin:   admin,
+		Action:  action,
+		Short:   short,
+		UserID:  userID,
+		Details: details,
+	}
+	log.Printf("Admin %s: %s %s %s %s", admin, action, short, userID, details)
+	if err := s.ds.SaveAudit(ctx, entry); err != nil {
+		return fmt.Errorf("%s is done, but not written to audit trail: %w", action, err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Admin(t *testing.T) {
+	c := &config.Config{LenShortURL: 5, Admins: "root, "}
+	s := New(repository.New(c), c)
+	asUser := func(userID string) context.Context {
+		return context.WithValue(context.Background(), config.ContextKeyUserID, userID)
+	}
+	ctx := asUser("root")
+
+	for _, userID := range []string{"root", "root2"} {
+		admin, err := s.IsAdmin(ctx, userID)
+		require.NoError(t, err)
+		assert.Equal(t, userID == "root", admin)
+	}
+
+	first, err := s.Post(asUser("user1"), "http://spam.example.com/a", model.ShortOpts{})
+	require.NoError(t, err)
+	_, err = s.Post(asUser("user2"), "http://spam.example.com/b", model.ShortOpts{})
+	require.NoError(t, err)
+	_, err = s.Post(asUser("user2"), "http://good.ru", model.ShortOpts{})
+	require.NoError(t, err)
+
+	found, err := s.AdminSearch(ctx, model.URLFilter{Query: "SPAM.example"})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	found, err = s.AdminSearch(ctx, model.URLFilter{Query: "spam", UserID: "user1"})
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	short := found[0].ID
+	assert.Equal(t, first, found[0].ShortURL)
+
+	deleted, err := s.AdminDelete(ctx, short)
+	require.NoError(t, err)
+	assert.True(t, deleted.Deleted)
+	_, err = s.AdminDelete(ctx, "nosuch")
+	assert.ErrorIs(t, err, config.ErrNoSuchRecord)
+	notDeleted := false
+	found, err = s.AdminSearch(ctx, model.URLFilter{Query: "spam", Deleted: &notDeleted})
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+
+	// url is taken by another short id, so record can't be restored
+	_, err = s.Post(asUser("user3"), "http://spam.example.com/a", model.ShortOpts{})
+	require.NoError(t, err)
+	_, err = s.AdminRestore(ctx, short)
+	assert.ErrorIs(t, err, config.ErrDuplicateURL)
+
+	for _, bad := range []string{"user2", strings.Repeat("A", 32), strings.Repeat("a", 33)} {
+		_, err = s.AdminReassign(ctx, short, bad)
+		assert.ErrorIs(t, err, config.ErrUserIDNotValid, bad)
+	}
+	_, err = s.AdminReassign(ctx, short, "")
+	assert.ErrorIs(t, err, config.ErrInvalidReqBody)
+	newOwner := strings.Repeat("2", 32)
+	reassigned, err := s.AdminReassign(ctx, short, newOwner)
+	require.NoError(t, err)
+	assert.Equal(t, newOwner, reassigned.UserID)
+
+	entries, err := s.AuditLog(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, model.AuditReassign, entries[0].Action)
+	assert.Equal(t, model.AuditDelete, entries[1].Action)
+	assert.Equal(t, "root", entries[1].Admin)
+	assert.Equal(t, "user1", entries[1].UserID)
+}
+
+// Memory is rolled back when storage does not save admin change
+func TestService_AdminRollback(t *testing.T) {
+	owner, another := strings.Repeat("1", 32), strings.Repeat("2", 32)
+	c := &config.Config{LenShortURL: 5, Admins: "root", FileStorage: filepath.Join(t.TempDir(), "storage")}
+	ds := repository.New(c)
+	s := New(ds, c)
+	short, err := s.Post(context.WithValue(context.Background(), config.ContextKeyUserID, owner), "http://rollback.ru", model.ShortOpts{})
+	require.NoError(t, err)
+
+	// log dir is replaced by file, so update is not saved
+	require.NoError(t, ds.Close())
+	matches, err := filepath.Glob(c.FileStorage + ".*")
+	require.NoError(t, err)
+	for _, path := range matches {
+		require.NoError(t, os.RemoveAll(path))
+		require.NoError(t, os.WriteFile(path, nil, 0600))
+	}
+	ctx := context.WithValue(context.Background(), config.ContextKeyUserID, "root")
+	_, err = s.AdminReassign(ctx, short, another)
+	require.Error(t, err)
+	_, err = s.AdminDelete(ctx, short)
+	require.Error(t, err)
+
+	found, err := s.AdminSearch(ctx, model.URLFilter{UserID: owner})
+	require.NoError(t, err)
+	require.Len(t, found, 1, "owner is rolled back")
+	assert.False(t, found[0].Deleted, "deletion is rolled back")
+	_, err = s.Get(ctx, short)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+)
+
+// API key is "shk_" and 48 random hex digits. Only sha256 of key is stored:
+// key is long random string, so slow hash like bcrypt is not needed,
+// and key can be found by its hash. Last use is saved not more often
+// than once per apiKeyTouchEvery.
+
+const (
+	apiKeyPrefix     = "shk_"
+	apiKeyByteSize   = 24
+	apiKeyShownLen   = len(apiKeyPrefix) + 8
+	maxKeyNameLen    = 64
+	apiKeyTouchEvery = time.Minute
+)
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func keyInfo(key model.APIKey) model.APIKeyInfo {
+	return model.APIKeyInfo{
+		ID:       key.ID,
+		Name:     key.Name,
+		Prefix:   key.Prefix,
+		Created:  key.Created,
+		LastUsed: key.LastUsed,
+		Revoked:  key.Revoked,
+	}
+}
+
+func checkKeyName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > maxKeyNameLen {
+		return config.ErrKeyNameNotValid
+	}
+	return nil
+}
+
+// Makes new key of user. Key itself is returned only here.
+func (s *Service) CreateAPIKey(ctx context.Context, userID, name string) (model.APIKeyInfo, error) {
+	if err := checkKeyName(name); err != nil {
+		return model.APIKeyInfo{}, err
+	}
+	buf := make([]byte, apiKeyByteSize)
+	if _, err := rand.Read(buf); err != nil {
+		return model.APIKeyInfo{}, err
+	}
+	secret := apiKeyPrefix + hex.EncodeToString(buf)
+	key := model.APIKey{
+		ID:      newRandomID(),
+		UserID:  userID,
+		Name:    name,
+		Prefix:  secret[:apiKeyShownLen],
+		Hash:    hashAPIKey(secret),
+		Created: time.Now().UTC(),
+	}
+	if err := s.ds.SaveAPIKey(ctx, key); err != nil {
+		return model.APIKeyInfo{}, err
+	}
+	res := keyInfo(key)
+	res.Key = secret
+	return res, nil
+}
+
+// Returns all keys of user, revoked ones too
+func (s *Service) GetAPIKeys(ctx context.Context, userID string) ([]model.APIKeyInfo, error) {
+	keys, err := s.ds.APIKeysByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]model.APIKeyInfo, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, keyInfo(key))
+	}
+	return res, nil
+}
+
+func (s *Service) RenameAPIKey(ctx context.Context, userID, id, name string) (model.APIKeyInfo, error) {
+	if err := checkKeyName(name); err != nil {
+		return model.APIKeyInfo{}, err
+	}
+	key, err := s.userAPIKey(ctx, userID, id)
+	if err != nil {
+		return model.APIKeyInfo{}, err
+	}
+	key.Name = name
+	if err := s.ds.SaveAPIKey(ctx, key); err != nil {
+		return model.APIKeyInfo{}, err
+	}
+	return keyInfo(key), nil
+}
+
+// Revoked key is kept to be shown in list, but is not accepted any more
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	key, err := s.userAPIKey(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if key.Revoked != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	key.Revoked = &now
+	return s.ds.SaveAPIKey(ctx, key)
+}
+
+// Key of another user is not found as well
+func (s *Service) userAPIKey(ctx context.Context, userID, id string) (model.APIKey, error) {
+	keys, err := s.ds.APIKeysByUser(ctx, userID)
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	for _, key := range keys {
+		if key.ID == id {
+			return key, nil
+		}
+	}
+	return model.APIKey{}, config.ErrNoSuchRecord
+}
+
+// Returns user id of valid key and marks key as used
+func (s *Service) UserByAPIKey(ctx context.Context, secret string) (string, error) {
+	key, err := s.ds.APIKeyByHash(ctx, hashAPIKey(secret))
+	switch {
+	case errors.Is(err, config.ErrNoSuchRecord):
+		return "", config.ErrAPIKeyNotValid
+	case err != nil:
+		return "", err
+	case key.Revoked != nil:
+		return "", config.ErrAPIKeyNotValid
+	}
+	now := time.Now().UTC()
+	if key.LastUsed == nil || now.Sub(*key.LastUsed) >= apiKeyTouchEvery {
+		if err := s.ds.TouchAPIKey(ctx, key.ID, now); err != nil {
+			log.Printf("Error in api key %s last use update: %v", key.ID, err)
+		}
+	}
+	return key.UserID, nil
+}
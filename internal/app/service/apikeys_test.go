@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_APIKeys(t *testing.T) {
+	c := &config.Config{LenShortURL: 5, FileStorage: filepath.Join(t.TempDir(), "storage")}
+	s := New(repository.New(c), c)
+	ctx := context.Background()
+
+	_, err := s.CreateAPIKey(ctx, "user1", "")
+	assert.ErrorIs(t, err, config.ErrKeyNameNotValid)
+	created, err := s.CreateAPIKey(ctx, "user1", "ci")
+	require.NoError(t, err)
+	assert.Contains(t, created.Key, created.Prefix)
+	_, err = s.CreateAPIKey(ctx, "user1", "deploy")
+	require.NoError(t, err)
+
+	userID, err := s.UserByAPIKey(ctx, created.Key)
+	require.NoError(t, err)
+	assert.Equal(t, "user1", userID)
+	_, err = s.UserByAPIKey(ctx, created.Key+"0")
+	assert.ErrorIs(t, err, config.ErrAPIKeyNotValid)
+
+	_, err = s.RenameAPIKey(ctx, "user2", created.ID, "stolen")
+	assert.ErrorIs(t, err, config.ErrNoSuchRecord)
+	_, err = s.RenameAPIKey(ctx, "user1", created.ID, "ci runner")
+	require.NoError(t, err)
+	require.NoError(t, s.RevokeAPIKey(ctx, "user1", created.ID))
+	_, err = s.UserByAPIKey(ctx, created.Key)
+	assert.ErrorIs(t, err, config.ErrAPIKeyNotValid)
+
+	// keys are read back from file, key itself is not kept
+	s = New(repository.New(c), c)
+	keys, err := s.GetAPIKeys(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "ci runner", keys[0].Name)
+	assert.NotNil(t, keys[0].LastUsed)
+	assert.NotNil(t, keys[0].Revoked)
+	assert.Empty(t, keys[0].Key)
+	assert.Nil(t, keys[1].Revoked)
+}
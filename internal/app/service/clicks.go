@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+)
+
+// clickCollector takes clicks from redirects into buffered channel and
+// saves them to repository in batches from its own goroutine, so redirect
+// never waits for storage. If buffer is full click is dropped.
+// When ctx is done buffered clicks are saved and done is closed.
+
+const clickBatch = 100
+
+type clickCollector struct {
+	ds      *repository.Repository
+	clicks  chan model.Click
+	flush   time.Duration
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+func newClickCollector(ds *repository.Repository, c *config.Config) *clickCollector {
+	size := c.ClickBuffer
+	if size <= 0 {
+		size = 1
+	}
+	flush := c.ClickFlush
+	if flush <= 0 {
+		flush = time.Second
+	}
+	return &clickCollector{
+		ds:     ds,
+		clicks: make(chan model.Click, size),
+		flush:  flush,
+		done:   make(chan struct{}),
+	}
+}
+
+func (cc *clickCollector) record(click model.Click) {
+	select {
+	case cc.clicks <- click:
+	default:
+		if cc.dropped.Add(1)%clickBatch == 1 {
+			log.Printf("click buffer is full, %d click(s) dropped", cc.dropped.Load())
+		}
+	}
+}
+
+func (cc *clickCollector) run(ctx context.Context) {
+	defer close(cc.done)
+	ticker := time.NewTicker(cc.flush)
+	defer ticker.Stop()
+	batch := make([]model.Click, 0, clickBatch)
+	save := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := cc.ds.SaveClicks(context.Background(), batch); err != nil {
+			log.Printf(" error saving clicks: %v\n", err)
+		}
+		batch = make([]model.Click, 0, clickBatch)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case click := <-cc.clicks:
+					batch = append(batch, click)
+					if len(batch) == clickBatch {
+						save()
+					}
+				default:
+					save()
+					return
+				}
+			}
+		case click := <-cc.clicks:
+			batch = append(batch, click)
+			if len(batch) == clickBatch {
+				save()
+			}
+		case <-ticker.C:
+			save()
+		}
+	}
+}
+
+// Records redirect by short url, never blocks
+func (s *Service) RecordClick(click model.Click) {
+	s.clicks.record(click)
+}
+
+// Returns redirects stats of short url owned by user
+func (s *Service) GetStats(ctx context.Context, userID, short, bucket string) (model.ClickStats, error) {
+	if bucket == "" {
+		bucket = config.DefaultStatBucket
+	}
+	if _, ok := config.StatBuckets[bucket]; !ok {
+		return model.ClickStats{}, config.ErrBucketNotValid
+	}
+	rec, ok, err := s.urls.get(ctx, short)
+	if err != nil {
+		return model.ClickStats{}, err
+	}
+	if !ok {
+		return model.ClickStats{}, config.ErrNoSuchRecord
+	}
+	if rec.UserID != userID {
+		return model.ClickStats{}, config.ErrNotOwner
+	}
+	stats, err := s.ds.ClickStats(ctx, short, bucket)
+	if err != nil {
+		return model.ClickStats{}, err
+	}
+	if hostName := s.hostName(); hostName != "" {
+		stats.ShortURL = hostName + short
+	}
+	return stats, nil
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/pool"
+)
+
+// Deletion request is saved to repository queue as job before user gets
+// reply, then it goes to one of deleter pool workers. Failed job is saved with
+// its progress and is queued again after backoff, until it runs out of
+// retries. Jobs not done before exit are taken from repository on start.
+
+const (
+	deleteBackoff    = time.Second
+	maxDeleteBackoff = time.Minute
+	// workers deleting short ids of one job
+	deleteFanout = 5
+)
+
+// Queues deletion of short urls of user, returns id of deletion job
+func (s *Service) DeleteURLs(ctx context.Context, shorts []string) (string, error) {
+	job := model.DeleteJob{
+		ID:      newRandomID(),
+		UserID:  ctx.Value(config.ContextKeyUserID).(string),
+		Shorts:  make([]string, 0, len(shorts)),
+		Results: make(map[string]string, len(shorts)),
+		Created: time.Now().UTC(),
+	}
+	for _, short := range shorts {
+		url, err := url.Parse(short)
+		if err != nil {
+			return "", err
+		}
+		job.Shorts = append(job.Shorts, strings.TrimPrefix(url.Path, "/"))
+	}
+	if err := s.ds.SaveDeleteJob(ctx, job); err != nil {
+		return "", err
+	}
+	s.scheduleDelete(context.Background(), job, 0)
+	return job.ID, nil
+}
+
+// Returns state and results of deletion job of user
+func (s *Service) GetDeleteJob(ctx context.Context, userID, id string) (model.DeleteJobStatus, error) {
+	job, err := s.ds.GetDeleteJob(ctx, id)
+	if err != nil {
+		return model.DeleteJobStatus{}, err
+	}
+	if job.UserID != userID {
+		return model.DeleteJobStatus{}, config.ErrNotOwner
+	}
+	res := model.DeleteJobStatus{
+		JobID:   job.ID,
+		Status:  model.JobQueued,
+		Total:   len(job.Shorts),
+		Error:   job.LastError,
+		Results: make([]model.DeleteResult, 0, len(job.Shorts)),
+	}
+	switch {
+	case job.Done && job.LastError != "":
+		res.Status = model.JobFailed
+	case job.Done:
+		res.Status = model.JobDone
+	case job.Attempts > 0:
+		res.Status = model.JobRetrying
+	}
+	hostName := s.hostName()
+	for _, short := range job.Shorts {
+		result := job.Results[short]
+		if result != "" {
+			res.Processed++
+		}
+		res.Results = append(res.Results, model.DeleteResult{ShortURL: hostName + short, Result: result})
+	}
+	return res, nil
+}
+
+// Starts pool of workers and queues jobs left from previous run
+func (s *Service) startDeleters(ctx context.Context) {
+	s.deletes = pool.New(ctx, pool.Options{
+		Size:    s.c.DeleteWorkers,
+		Queue:   s.c.DeleteWorkers,
+		Timeout: s.c.DeleteTimeout,
+	}, s.runDeleteJob)
+	s.deletesDone = make(chan struct{})
+	go s.finishDeleteJobs(ctx)
+
+	jobs, err := s.ds.PendingDeleteJobs(ctx)
+	if err != nil {
+		log.Printf(" error reading deletion queue: %v\n", err)
+		return
+	}
+	if len(jobs) > 0 {
+		log.Printf("%d deletion job(s) resumed", len(jobs))
+	}
+	for _, job := range jobs {
+		s.scheduleDelete(ctx, job, 0)
+	}
+}
+
+// Never blocks, job waits for free worker in its own goroutine
+func (s *Service) scheduleDelete(ctx context.Context, job model.DeleteJob, delay time.Duration) {
+	if delay > 0 {
+		time.AfterFunc(delay, func() {
+			s.submitDelete(ctx, job)
+		})
+		return
+	}
+	// Close waits for it, so job accepted before close is done
+	s.submitting.Add(1)
+	go func() {
+		defer s.submitting.Done()
+		s.submitDelete(ctx, job)
+	}()
+}
+
+func (s *Service) submitDelete(ctx context.Context, job model.DeleteJob) {
+	err := s.deletes.Submit(ctx, &job)
+	switch {
+	case errors.Is(err, pool.ErrClosed), errors.Is(err, context.Canceled):
+		// service is closing, job is in repository queue and will be run after restart
+		log.Printf("deletion job %s is left for next start", job.ID)
+	case err != nil:
+		log.Printf(" error queueing deletion job %s: %v\n", job.ID, err)
+	}
+}
+
+// Saves results of jobs done by pool, failed ones are queued again
+func (s *Service) finishDeleteJobs(ctx context.Context) {
+	defer close(s.deletesDone)
+	for res := range s.deletes.Results() {
+		job := res.Item
+		job.Attempts++
+		switch {
+		case res.Err == nil:
+			job.Done, job.LastError = true, ""
+		case job.Attempts >= s.c.DeleteRetries:
+			job.Done, job.LastError = true, res.Err.Error()
+			log.Printf(" error deleting urls, job %s given up after %d attempt(s): %v\n", job.ID, job.Attempts, res.Err)
+		default:
+			job.LastError = res.Err.Error()
+			log.Printf(" error deleting urls, job %s will be retried: %v\n", job.ID, res.Err)
+		}
+		if err := s.ds.SaveDeleteJob(ctx, *job); err != nil {
+			// job stays in queue as saved last time and is run again after restart
+			log.Printf(" error saving deletion job %s: %v\n", job.ID, err)
+		}
+		if !job.Done {
+			s.scheduleDelete(ctx, *job, deleteDelay(job.Attempts))
+		}
+	}
+}
+
+// Exponential backoff after given number of failed attempts
+func deleteDelay(attempts int) time.Duration {
+	delay := deleteBackoff
+	for ik := 1; ik < attempts && delay < maxDeleteBackoff; ik++ {
+		delay *= 2
+	}
+	if delay > maxDeleteBackoff {
+		delay = maxDeleteBackoff
+	}
+	return delay
+}
+
+// Marks records of job as deleted and saves them. Short ids already
+// done by previous attempts are skipped, except deleted ones: their
+// saving might have failed, so they are saved again.
+func (s *Service) runDeleteJob(ctx context.Context, job *model.DeleteJob) error {
+	if job.Results == nil {
+		job.Results = make(map[string]string, len(job.Shorts))
+	}
+	todo := make([]*model.ShortURL, 0, len(job.Shorts))
+	for _, short := range job.Shorts {
+		if result := job.Results[short]; result == "" || result == model.DeleteDone {
+			todo = append(todo, &model.ShortURL{Short: short})
+		}
+	}
+
+	mu := sync.Mutex{}
+	ctx = context.WithValue(ctx, config.ContextKeyUserID, job.UserID)
+	results := pool.Process(ctx, pool.Options{Size: deleteFanout}, todo, func(ctx context.Context, URL *model.ShortURL) error {
+		result, err := s.markDeleted(ctx, URL, false)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if result == model.DeleteAlready && job.Results[URL.Short] == model.DeleteDone {
+			result = model.DeleteDone
+		}
+		job.Results[URL.Short] = result
+		return nil
+	})
+	errs := make([]error, 0)
+	for _, res := range results {
+		if res.Err != nil {
+			errs = append(errs, res.Err)
+		}
+	}
+	if len(results) < len(todo) {
+		errs = append(errs, ctx.Err())
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	deleted := make([]*model.ShortURL, 0, len(todo))
+	for _, rec := range todo {
+		if job.Results[rec.Short] == model.DeleteDone {
+			deleted = append(deleted, rec)
+		}
+	}
+	return s.ds.UpdateBatch(ctx, deleted)
+}
+
+// Marks record as deleted in store and returns result of deletion.
+// URL is the job own copy of record and is updated unless record
+// belongs to another user. Admin deletes with force, whoever owns record.
+func (s *Service) markDeleted(ctx context.Context, URL *model.ShortURL, force bool) (string, error) {
+	userID := ctx.Value(config.ContextKeyUserID).(string)
+	result := model.DeleteDone
+	rec, err := s.urls.update(ctx, URL.Short, func(rec *model.ShortURL) error {
+		switch {
+		case !force && userID != rec.UserID:
+			result = model.DeleteNotOwner
+		case rec.Deleted:
+			result = model.DeleteAlready
+		default:
+			rec.Deleted = true
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, config.ErrNoSuchRecord):
+		return model.DeleteNotFound, nil
+	case err != nil:
+		return "", err
+	}
+	if result != model.DeleteNotOwner {
+		*URL = rec
+	}
+	return result, nil
+}
+
+// Returns random 32 hex chars id
+func newRandomID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_DeleteURLs(t *testing.T) {
+	c := &config.Config{
+		LenShortURL:   5,
+		FileStorage:   filepath.Join(t.TempDir(), "test.stor"),
+		Fsync:         config.FsyncNever,
+		DeleteWorkers: 2,
+		DeleteRetries: 3,
+	}
+	ds := repository.New(c)
+	s := New(ds, c)
+	owner := context.WithValue(context.Background(), config.ContextKeyUserID, "owner")
+	other := context.WithValue(context.Background(), config.ContextKeyUserID, "other")
+
+	for _, alias := range []string{"first", "second"} {
+		_, err := s.Post(owner, "http://"+alias+".ru", model.ShortOpts{Alias: alias})
+		require.NoError(t, err)
+	}
+	_, err := s.Post(other, "http://foreign.ru", model.ShortOpts{Alias: "foreign"})
+	require.NoError(t, err)
+
+	first, err := s.DeleteURLs(owner, []string{"first", "foreign", "unknown"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err := s.GetDeleteJob(owner, "owner", first)
+		return err == nil && job.Status == model.JobDone
+	}, 2*time.Second, 10*time.Millisecond)
+	second, err := s.DeleteURLs(owner, []string{"/first", "http://localhost:8080/second"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err := s.GetDeleteJob(owner, "owner", second)
+		return err == nil && job.Status == model.JobDone
+	}, 2*time.Second, 10*time.Millisecond)
+
+	results := map[string]string{}
+	for _, id := range []string{first, second} {
+		job, err := ds.GetDeleteJob(context.Background(), id)
+		require.NoError(t, err)
+		for short, result := range job.Results {
+			results[id+" "+short] = result
+		}
+	}
+	assert.Equal(t, map[string]string{
+		first + " first":   model.DeleteDone,
+		first + " foreign": model.DeleteNotOwner,
+		first + " unknown": model.DeleteNotFound,
+		second + " first":  model.DeleteAlready,
+		second + " second": model.DeleteDone,
+	}, results)
+
+	_, err = s.GetDeleteJob(other, "other", first)
+	assert.ErrorIs(t, err, config.ErrNotOwner)
+
+	_, err = s.Get(owner, "second")
+	assert.ErrorIs(t, err, config.ErrURLDeleted)
+	_, err = s.Get(owner, "foreign")
+	assert.NoError(t, err)
+
+	// deletions are saved
+	s = New(repository.New(c), c)
+	_, err = s.Get(owner, "first")
+	assert.ErrorIs(t, err, config.ErrURLDeleted)
+}
+
+func TestService_DeleteResumed(t *testing.T) {
+	c := &config.Config{
+		LenShortURL:   5,
+		FileStorage:   filepath.Join(t.TempDir(), "test.stor"),
+		Fsync:         config.FsyncNever,
+		DeleteWorkers: 1,
+		DeleteRetries: 3,
+	}
+	owner := context.WithValue(context.Background(), config.ContextKeyUserID, "owner")
+	s := New(repository.New(c), c)
+	_, err := s.Post(owner, "http://resumed.ru", model.ShortOpts{Alias: "resumed"})
+	require.NoError(t, err)
+
+	// job queued, but process exited before it was run
+	ds := repository.New(c)
+	require.NoError(t, ds.SaveDeleteJob(owner, model.DeleteJob{ID: "job", UserID: "owner", Shorts: []string{"resumed"}}))
+
+	ds = repository.New(c)
+	s = New(ds, c)
+	require.Eventually(t, func() bool {
+		job, err := ds.GetDeleteJob(context.Background(), "job")
+		return err == nil && job.Done
+	}, 2*time.Second, 10*time.Millisecond)
+	_, err = s.Get(owner, "resumed")
+	assert.ErrorIs(t, err, config.ErrURLDeleted)
+}
+
+func TestDeleteDelay(t *testing.T) {
+	assert.Equal(t, deleteBackoff, deleteDelay(1))
+	assert.Equal(t, 4*deleteBackoff, deleteDelay(3))
+	assert.Equal(t, maxDeleteBackoff, deleteDelay(100))
+}
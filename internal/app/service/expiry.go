@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+)
+
+// Returns expiration time given in opts either by ttl or by absolute
+// time, or nil if link should live forever.
+func parseExpiry(opts model.ShortOpts, now time.Time) (*time.Time, error) {
+	switch {
+	case opts.TTL != "" && opts.ExpiresAt != "":
+		return nil, config.ErrExpiryNotValid
+	case opts.TTL != "":
+		ttl, err := time.ParseDuration(opts.TTL)
+		if err != nil {
+			// plain number is ttl in seconds
+			sec, errInt := strconv.Atoi(opts.TTL)
+			if errInt != nil {
+				return nil, config.ErrExpiryNotValid
+			}
+			ttl = time.Duration(sec) * time.Second
+		}
+		if ttl <= 0 {
+			return nil, config.ErrExpiryNotValid
+		}
+		expires := now.Add(ttl)
+		return &expires, nil
+	case opts.ExpiresAt != "":
+		expires, err := time.Parse(time.RFC3339, opts.ExpiresAt)
+		if err != nil || !expires.After(now) {
+			return nil, config.ErrExpiryNotValid
+		}
+		return &expires, nil
+	}
+	return nil, nil
+}
+
+func isExpired(rec *model.ShortURL, now time.Time) bool {
+	return rec.ExpiresAt != nil && !now.Before(*rec.ExpiresAt)
+}
+
+// Periodically marks expired links as deleted and saves them,
+// so they leave reverse index and their urls can be shortened again.
+func (s *Service) runReaper(ctx context.Context) {
+	ticker := time.NewTicker(s.c.ReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.reapExpired(ctx, time.Now()); err != nil {
+				log.Printf(" error saving expired urls: %v\n", err)
+			}
+		}
+	}
+}
+
+func (s *Service) reapExpired(ctx context.Context, now time.Time) error {
+	candidates, err := s.urls.expired(ctx, now)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	expired := make([]*model.ShortURL, 0, len(candidates))
+	for _, cand := range candidates {
+		rec, ok, err := s.markExpired(ctx, cand.Short, now)
+		if err != nil {
+			return err
+		}
+		if ok {
+			expired = append(expired, &rec)
+		}
+	}
+	log.Printf("%d expired url(s) reaped", len(expired))
+	return s.ds.UpdateBatch(ctx, expired)
+}
+
+// Marks link deleted if it is expired, returns true if it was changed
+func (s *Service) markExpired(ctx context.Context, short string, now time.Time) (model.ShortURL, bool, error) {
+	changed := false
+	rec, err := s.urls.update(ctx, short, func(rec *model.ShortURL) error {
+		if !rec.Deleted && isExpired(rec, now) {
+			rec.Deleted = true
+			changed = true
+		}
+		return nil
+	})
+	return rec, changed, err
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		opts    model.ShortOpts
+		want    time.Duration
+		wantErr bool
+	}{
+		{opts: model.ShortOpts{}},
+		{opts: model.ShortOpts{TTL: "72h"}, want: 72 * time.Hour},
+		{opts: model.ShortOpts{TTL: "3600"}, want: time.Hour},
+		{opts: model.ShortOpts{ExpiresAt: "2023-05-02T12:00:00Z"}, want: 24 * time.Hour},
+		{opts: model.ShortOpts{TTL: "-1h"}, wantErr: true},
+		{opts: model.ShortOpts{TTL: "soon"}, wantErr: true},
+		{opts: model.ShortOpts{ExpiresAt: "2023-04-30T12:00:00Z"}, wantErr: true},
+		{opts: model.ShortOpts{TTL: "1h", ExpiresAt: "2023-05-02T12:00:00Z"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		expires, err := parseExpiry(tt.opts, now)
+		if tt.wantErr {
+			assert.ErrorIs(t, err, config.ErrExpiryNotValid, "%+v", tt.opts)
+			continue
+		}
+		require.NoError(t, err)
+		if tt.want == 0 {
+			assert.Nil(t, expires)
+			continue
+		}
+		assert.Equal(t, now.Add(tt.want), expires.UTC())
+	}
+}
+
+func TestService_Expiry(t *testing.T) {
+	c := &config.Config{LenShortURL: 5}
+	s := New(repository.New(c), c)
+	ctx := context.WithValue(context.Background(), config.ContextKeyUserID, "user")
+
+	short, err := s.Post(ctx, "http://expire.ru", model.ShortOpts{TTL: "1h", Alias: "expire"})
+	require.NoError(t, err)
+	assert.Equal(t, "expire", short)
+	_, err = s.Get(ctx, "expire")
+	require.NoError(t, err)
+
+	require.NoError(t, s.reapExpired(ctx, time.Now().Add(2*time.Hour)))
+	_, err = s.Get(ctx, "expire")
+	assert.ErrorIs(t, err, config.ErrURLDeleted)
+
+	// url of reaped link is free again
+	short, err = s.Post(ctx, "http://expire.ru", model.ShortOpts{})
+	require.NoError(t, err)
+	assert.NotEqual(t, "expire", short)
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+)
+
+// Generator produces candidate short ids for url. Result is at least
+// length chars long. attempt is number of collisions already happened
+// for this url, so deterministic generators can give another candidate.
+type Generator interface {
+	Generate(URL string, attempt, length int) string
+}
+
+const base62 = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// Returns generator chosen in config. stored is number of already
+// stored records, sequential generators start counting from it.
+func NewGenerator(c *config.Config, stored int) (Generator, error) {
+	switch c.ShortGen {
+	case config.GenRandom, "":
+		alphabet := c.ShortAlpha
+		if alphabet == "" {
+			alphabet = config.DefaultAlphabet
+		}
+		return &randGen{alphabet: []byte(alphabet)}, nil
+	case config.GenCounter:
+		g := &counterGen{}
+		g.counter.Store(uint64(stored))
+		return g, nil
+	case config.GenHash:
+		return &hashGen{salt: c.ShortSalt}, nil
+	case config.GenSqids:
+		return newSqidsGen(c.ShortSalt, uint64(stored)), nil
+	}
+	return nil, fmt.Errorf("unknown short id generator %q", c.ShortGen)
+}
+
+// randGen draws every char at random from alphabet
+type randGen struct {
+	alphabet []byte
+}
+
+func (g *randGen) Generate(URL string, attempt, length int) string {
+	res := make([]byte, length)
+	for ik := 0; ik < length; ik++ {
+		res[ik] = g.alphabet[rand.Intn(len(g.alphabet))]
+	}
+	return string(res)
+}
+
+// counterGen encodes monotonic counter in base62,
+// id gets longer by itself when counter outgrows length.
+type counterGen struct {
+	counter atomic.Uint64
+}
+
+func (g *counterGen) Generate(URL string, attempt, length int) string {
+	return encodeBase62(g.counter.Add(1), length, base62)
+}
+
+// hashGen gives the same id for the same url (and salt), on collisions
+// attempt number is hashed too.
+type hashGen struct {
+	salt string
+}
+
+func (g *hashGen) Generate(URL string, attempt, length int) string {
+	h := sha256.New()
+	h.Write([]byte(g.salt))
+	h.Write([]byte(URL))
+	if attempt > 0 {
+		h.Write([]byte(strconv.Itoa(attempt)))
+	}
+	sum := h.Sum(nil)
+	res := make([]byte, 0, length)
+	// every 8 bytes of hash give 10 base62 chars
+	for ik := 0; len(res) < length; ik = (ik + 8) % len(sum) {
+		num := binary.BigEndian.Uint64(sum[ik : ik+8])
+		for jk := 0; jk < 10 && len(res) < length; jk++ {
+			res = append(res, base62[num%62])
+			num /= 62
+		}
+	}
+	return string(res)
+}
+
+// sqidsGen hides sequence of counter: within keyspace of given length
+// counter is mapped by bijection n -> (n*prime + offset) mod 62^length
+// and encoded with alphabet shuffled by salt. So ids look random but
+// never repeat until keyspace of the length is exhausted, and then
+// length grows because of collisions.
+type sqidsGen struct {
+	counter  atomic.Uint64
+	alphabet string
+	offset   uint64
+}
+
+// prime is coprime with 62, so multiplication by it is bijection mod 62^n
+const sqidsPrime = 1580030173
+
+// 62^10 fits in uint64, longer ids are padded
+const sqidsMaxLen = 10
+
+func newSqidsGen(salt string, start uint64) *sqidsGen {
+	seed := sha256.Sum256([]byte(salt))
+	alphabet := []byte(base62)
+	rnd := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seed[:8]))))
+	rnd.Shuffle(len(alphabet), func(i, j int) {
+		alphabet[i], alphabet[j] = alphabet[j], alphabet[i]
+	})
+	g := &sqidsGen{
+		alphabet: string(alphabet),
+		offset:   binary.BigEndian.Uint64(seed[8:16]),
+	}
+	g.counter.Store(start)
+	return g
+}
+
+func (g *sqidsGen) Generate(URL string, attempt, length int) string {
+	n := g.counter.Add(1)
+	keyLen := length
+	if keyLen > sqidsMaxLen {
+		keyLen = sqidsMaxLen
+	}
+	keyspace := uint64(1)
+	for ik := 0; ik < keyLen; ik++ {
+		keyspace *= 62
+	}
+	hi, lo := bits.Mul64(n%keyspace, sqidsPrime)
+	num := (bits.Rem64(hi, lo, keyspace) + g.offset%keyspace) % keyspace
+	return encodeBase62(num, length, g.alphabet)
+}
+
+// Encodes num with 62 chars alphabet, padded to length with first char
+func encodeBase62(num uint64, length int, alphabet string) string {
+	res := make([]byte, 0, length)
+	for num > 0 {
+		res = append(res, alphabet[num%62])
+		num /= 62
+	}
+	for len(res) < length {
+		res = append(res, alphabet[0])
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return string(res)
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerators(t *testing.T) {
+	for _, name := range []string{config.GenRandom, config.GenCounter, config.GenHash, config.GenSqids} {
+		t.Run(name, func(t *testing.T) {
+			gen, err := NewGenerator(&config.Config{ShortGen: name, ShortAlpha: "ab01"}, 0)
+			require.NoError(t, err)
+			seen := make(map[string]bool)
+			for ik := 0; ik < 1000; ik++ {
+				short := gen.Generate("http://some.url/"+string(rune('a'+ik%26)), ik/26, 5)
+				assert.GreaterOrEqual(t, len(short), 5)
+				if name != config.GenRandom {
+					assert.False(t, seen[short], "%s repeated", short)
+				}
+				seen[short] = true
+			}
+		})
+	}
+
+	_, err := NewGenerator(&config.Config{ShortGen: "nosuch"}, 0)
+	assert.Error(t, err)
+
+	gen, _ := NewGenerator(&config.Config{ShortGen: config.GenHash, ShortSalt: "salt"}, 0)
+	assert.Equal(t, gen.Generate("http://a.ru", 0, 7), gen.Generate("http://a.ru", 0, 7))
+	assert.NotEqual(t, gen.Generate("http://a.ru", 0, 7), gen.Generate("http://a.ru", 1, 7))
+}
+
+func TestService_LenKeyGrows(t *testing.T) {
+	c := &config.Config{LenShortURL: 1, ShortGen: config.GenRandom, ShortAlpha: "ab"}
+	s := New(repository.New(c), c)
+	ctx := context.WithValue(context.Background(), config.ContextKeyUserID, "user")
+
+	// keyspace of 1 char from 2 letters is exhausted after two urls
+	for ik := 0; ik < 20; ik++ {
+		short, err := s.Post(ctx, "http://host.ru/"+string(rune('a'+ik)), model.ShortOpts{})
+		require.NoError(t, err)
+		assert.NotEmpty(t, short)
+	}
+	assert.Greater(t, int(s.lenKey.Load()), 1)
+	stored, err := s.GetLen(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 20, stored)
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+)
+
+// urlIndex is concurrency-safe in-memory storage of short url records.
+// Records are spread by short id over fixed number of shards, each shard
+// has its own lock, so requests for different ids do not block each other.
+// Records are never given out by pointer: readers get copies, writers
+// change records only inside update() under the shard lock.
+//
+// Besides short id -> record shards there is reverse index: original url
+// (in lower case, as urls are compared case insensitive) -> short id.
+// It holds only not deleted records. When both kinds of locks are needed
+// reverse shard is locked first.
+
+const shardCount = 32
+
+var errShortBusy = errors.New("short id is buzy")
+
+type indexShard struct {
+	mu   sync.RWMutex
+	urls map[string]*model.ShortURL
+}
+
+type reverseShard struct {
+	mu     sync.RWMutex
+	shorts map[string]string
+}
+
+type urlIndex struct {
+	shards  [shardCount]*indexShard
+	reverse [shardCount]*reverseShard
+}
+
+func newURLIndex() *urlIndex {
+	idx := &urlIndex{}
+	for ik := range idx.shards {
+		idx.shards[ik] = &indexShard{
+			urls: make(map[string]*model.ShortURL),
+		}
+		idx.reverse[ik] = &reverseShard{
+			shorts: make(map[string]string),
+		}
+	}
+	return idx
+}
+
+// fnv-1a hash of key, picks the shard
+func shardNum(key string) uint32 {
+	h := uint32(2166136261)
+	for ik := 0; ik < len(key); ik++ {
+		h ^= uint32(key[ik])
+		h *= 16777619
+	}
+	return h % shardCount
+}
+
+func (idx *urlIndex) shard(short string) *indexShard {
+	return idx.shards[shardNum(short)]
+}
+
+func urlKey(URL string) string {
+	return strings.ToLower(URL)
+}
+
+func (idx *urlIndex) reverseShard(key string) *reverseShard {
+	return idx.reverse[shardNum(key)]
+}
+
+// Fills index with records loaded from repository
+func (idx *urlIndex) load(data map[string]*model.ShortURL) {
+	for short, rec := range data {
+		sh := idx.shard(short)
+		sh.mu.Lock()
+		sh.urls[short] = rec
+		sh.mu.Unlock()
+		if !rec.Deleted {
+			idx.link(rec.URL, short)
+		}
+	}
+}
+
+// Returns short id of not deleted record for given url
+func (idx *urlIndex) findShort(URL string) (string, bool) {
+	key := urlKey(URL)
+	rsh := idx.reverseShard(key)
+	rsh.mu.RLock()
+	defer rsh.mu.RUnlock()
+	short, ok := rsh.shorts[key]
+	return short, ok
+}
+
+// Adds url -> short pair into reverse index, if url is not there yet
+func (idx *urlIndex) link(URL, short string) {
+	key := urlKey(URL)
+	rsh := idx.reverseShard(key)
+	rsh.mu.Lock()
+	if _, ok := rsh.shorts[key]; !ok {
+		rsh.shorts[key] = short
+	}
+	rsh.mu.Unlock()
+}
+
+// Removes url -> short pair from reverse index, if url still points to short
+func (idx *urlIndex) unlink(URL, short string) {
+	key := urlKey(URL)
+	rsh := idx.reverseShard(key)
+	rsh.mu.Lock()
+	if rsh.shorts[key] == short {
+		delete(rsh.shorts, key)
+	}
+	rsh.mu.Unlock()
+}
+
+// Keeps reverse index in sync with changed record
+func (idx *urlIndex) relink(old, changed model.ShortURL) {
+	sameURL := strings.EqualFold(old.URL, changed.URL)
+	if !old.Deleted && (changed.Deleted || !sameURL) {
+		idx.unlink(old.URL, old.Short)
+	}
+	if !changed.Deleted && (old.Deleted || !sameURL) {
+		idx.link(changed.URL, changed.Short)
+	}
+}
+
+// Returns copy of record with given short id
+func (idx *urlIndex) get(short string) (model.ShortURL, bool) {
+	sh := idx.shard(short)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	rec, ok := sh.urls[short]
+	if !ok {
+		return model.ShortURL{}, false
+	}
+	return *rec, true
+}
+
+// Stores new record. If record url is already stored returns
+// its short id and ErrDuplicateURL, if record short id is already
+// buzy returns errShortBusy.
+func (idx *urlIndex) add(rec model.ShortURL) (string, error) {
+	key := urlKey(rec.URL)
+	rsh := idx.reverseShard(key)
+	rsh.mu.Lock()
+	defer rsh.mu.Unlock()
+	if short, ok := rsh.shorts[key]; ok {
+		return short, config.ErrDuplicateURL
+	}
+
+	sh := idx.shard(rec.Short)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.urls[rec.Short]; ok {
+		return "", errShortBusy
+	}
+	sh.urls[rec.Short] = &rec
+	rsh.shorts[key] = rec.Short
+	return rec.Short, nil
+}
+
+func (idx *urlIndex) remove(short string) {
+	sh := idx.shard(short)
+	sh.mu.Lock()
+	rec, ok := sh.urls[short]
+	delete(sh.urls, short)
+	sh.mu.Unlock()
+	if ok && !rec.Deleted {
+		idx.unlink(rec.URL, short)
+	}
+}
+
+// Applies fn to record under the shard lock and returns copy of changed record.
+// If fn returns error record is left as is.
+func (idx *urlIndex) update(short string, fn func(rec *model.ShortURL) error) (model.ShortURL, error) {
+	sh := idx.shard(short)
+	sh.mu.Lock()
+	rec, ok := sh.urls[short]
+	if !ok {
+		sh.mu.Unlock()
+		return model.ShortURL{}, config.ErrNoSuchRecord
+	}
+	old := *rec
+	changed := *rec
+	if err := fn(&changed); err != nil {
+		sh.mu.Unlock()
+		return old, err
+	}
+	*rec = changed
+	sh.mu.Unlock()
+
+	idx.relink(old, changed)
+	return changed, nil
+}
+
+// Returns copies of all records accepted by filter
+func (idx *urlIndex) filter(fn func(rec *model.ShortURL) bool) []model.ShortURL {
+	res := make([]model.ShortURL, 0)
+	for _, sh := range idx.shards {
+		sh.mu.RLock()
+		for _, rec := range sh.urls {
+			if fn(rec) {
+				res = append(res, *rec)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return res
+}
+
+func (idx *urlIndex) len() int {
+	res := 0
+	for _, sh := range idx.shards {
+		sh.mu.RLock()
+		res += len(sh.urls)
+		sh.mu.RUnlock()
+	}
+	return res
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLIndex_Reverse(t *testing.T) {
+	idx := newURLIndex()
+	idx.load(map[string]*model.ShortURL{
+		"aaaaa": {Short: "aaaaa", URL: "http://loaded.ru"},
+		"bbbbb": {Short: "bbbbb", URL: "http://gone.ru", Deleted: true},
+	})
+
+	short, ok := idx.findShort("HTTP://LOADED.RU")
+	require.True(t, ok)
+	assert.Equal(t, "aaaaa", short)
+	_, ok = idx.findShort("http://gone.ru")
+	assert.False(t, ok, "deleted records are not in reverse index")
+
+	short, err := idx.add(model.ShortURL{Short: "ccccc", URL: "http://loaded.ru"})
+	assert.ErrorIs(t, err, config.ErrDuplicateURL)
+	assert.Equal(t, "aaaaa", short)
+
+	_, err = idx.add(model.ShortURL{Short: "aaaaa", URL: "http://new.ru"})
+	assert.ErrorIs(t, err, errShortBusy)
+	_, ok = idx.findShort("http://new.ru")
+	assert.False(t, ok, "failed add leaves reverse index untouched")
+
+	_, err = idx.update("aaaaa", func(rec *model.ShortURL) error {
+		rec.Deleted = true
+		return nil
+	})
+	require.NoError(t, err)
+	_, ok = idx.findShort("http://loaded.ru")
+	assert.False(t, ok, "deleted url can be shortened again")
+
+	short, err = idx.add(model.ShortURL{Short: "ddddd", URL: "http://loaded.ru"})
+	require.NoError(t, err)
+	assert.Equal(t, "ddddd", short)
+
+	idx.remove("ddddd")
+	_, ok = idx.findShort("http://loaded.ru")
+	assert.False(t, ok)
+	assert.Equal(t, 2, idx.len())
+}
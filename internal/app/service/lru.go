@@ -0,0 +1,87 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru is bounded cache, which evicts least recently used entry
+// when it is full. Counts hits, misses and evictions.
+type lru[K comparable, V any] struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // front is most recently used
+	items map[K]*list.Element
+	stats cacheStats
+}
+
+type lruEntry[K comparable, V any] struct {
+	key K
+	val V
+}
+
+type cacheStats struct {
+	Len       int
+	Hits      int64
+	Misses    int64
+	Evictions int64
+}
+
+func newLRU[K comparable, V any](size int) *lru[K, V] {
+	if size < 1 {
+		size = 1
+	}
+	return &lru[K, V]{
+		size:  size,
+		order: list.New(),
+		items: make(map[K]*list.Element, size),
+	}
+}
+
+func (c *lru[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		var empty V
+		return empty, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry[K, V]).val, true
+}
+
+func (c *lru[K, V]) put(key K, val V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruEntry[K, V]).val = val
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[K, V]{key: key, val: val})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[K, V]).key)
+		c.stats.Evictions++
+	}
+}
+
+func (c *lru[K, V]) remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+func (c *lru[K, V]) getStats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := c.stats
+	res.Len = c.order.Len()
+	return res
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	c := newLRU[string, int](2)
+	c.put("a", 1)
+	c.put("b", 2)
+	_, ok := c.get("a") // b is least recently used now
+	assert.True(t, ok)
+	c.put("c", 3)
+
+	_, ok = c.get("b")
+	assert.False(t, ok, "b should be evicted")
+	val, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, val)
+
+	c.put("a", 10)
+	val, _ = c.get("a")
+	assert.Equal(t, 10, val)
+	c.remove("a")
+	_, ok = c.get("a")
+	assert.False(t, ok)
+
+	stats := c.getStats()
+	assert.Equal(t, 1, stats.Len)
+	assert.Equal(t, int64(3), stats.Hits)
+	assert.Equal(t, int64(2), stats.Misses)
+	assert.Equal(t, int64(1), stats.Evictions)
+}
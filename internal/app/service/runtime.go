@@ -0,0 +1,60 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+)
+
+// Runtime part of config is swapped as a whole on reload, so request
+// sees either old or new values, never a mix of them.
+type runtimeConfig struct {
+	hostName string          // empty if short ids are returned without host
+	admins   map[string]bool // given by config
+	blocked  map[string]bool // hosts in lower case
+}
+
+func (s *Service) Reload(rt config.Runtime) {
+	next := &runtimeConfig{
+		admins:  parseAdmins(rt.Admins),
+		blocked: make(map[string]bool),
+	}
+	for _, host := range strings.Split(rt.BlockedHosts, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			next.blocked[host] = true
+		}
+	}
+	if rt.RetShrtWHost {
+		next.hostName = s.c.HostName
+	}
+	s.rt.Store(next)
+}
+
+// Prefix of short urls given to users
+func (s *Service) hostName() string {
+	return s.rt.Load().hostName
+}
+
+// Url of blocked host or of its subdomain is not shortened
+func (s *Service) isBlocked(URL string) bool {
+	blocked := s.rt.Load().blocked
+	if len(blocked) == 0 {
+		return false
+	}
+	u, err := url.Parse(URL)
+	if err != nil {
+		return false
+	}
+	for host := strings.ToLower(u.Hostname()); host != ""; {
+		if blocked[host] {
+			return true
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			break
+		}
+		host = parent
+	}
+	return false
+}
@@ -2,22 +2,33 @@ package service
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log"
-	"math/rand"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/pool"
 	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
 )
 
 type Service struct {
-	c    *config.Config
-	ds   *repository.Repository
-	urls map[string]*model.ShortURL
+	c       *config.Config
+	ds      *repository.Repository
+	urls    urlStore
+	gen     Generator
+	lenKey  atomic.Int32 // current length of generated short ids
+	clicks  *clickCollector
+	deletes *pool.Pool[*model.DeleteJob]
+	rt      atomic.Pointer[runtimeConfig] // reloadable part of config
+
+	stop        context.CancelFunc // stops background goroutines
+	deletesDone chan struct{}      // closed when deletion pool is drained
+	submitting  sync.WaitGroup     // deletion jobs on their way to pool
 }
 
 // Constructor
@@ -25,13 +36,63 @@ func New(ds *repository.Repository, c *config.Config) *Service {
 	s := &Service{}
 	s.c = c
 	s.ds = ds
-	s.urls = make(map[string]*model.ShortURL, 0)
-	ds.Load(context.Background(), s.urls)
+	s.Reload(c.Runtime())
+	if c.LazyLoad {
+		s.urls = newLazyStore(ds, c)
+	} else {
+		s.urls = newFullStore(context.Background(), ds)
+	}
+	stored, err := s.urls.len(context.Background())
+	if err != nil {
+		log.Println(err)
+	}
+	gen, err := NewGenerator(c, stored)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.gen = gen
+	s.lenKey.Store(int32(c.LenShortURL))
+	ctx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+	if c.ReapInterval > 0 {
+		go s.runReaper(ctx)
+	}
+	if c.LazyLoad && c.CacheMetrics > 0 {
+		go s.logMetrics(ctx)
+	}
+	s.clicks = newClickCollector(ds, c)
+	go s.clicks.run(ctx)
+	s.startDeleters(ctx)
 	return s
 }
 
+// Stops background work. Queued deletion jobs are done and buffered
+// clicks are saved, unless ctx is done first. Jobs left undone stay
+// in repository queue and are resumed on next start.
+func (s *Service) Close(ctx context.Context) error {
+	go func() {
+		s.submitting.Wait()
+		s.deletes.Close()
+	}()
+	select {
+	case <-s.deletesDone:
+		log.Println("deletion queue is drained")
+	case <-ctx.Done():
+		log.Println("deletion queue is not drained in time, jobs left will be resumed on next start")
+	}
+	// interrupts deletion jobs still running
+	s.stop()
+	select {
+	case <-s.clicks.done:
+		log.Println("clicks are flushed")
+	case <-ctx.Done():
+		log.Println("clicks are not flushed in time")
+	}
+	return ctx.Err()
+}
+
 // Generate and save short url for giver URL
-func (s *Service) Post(ctx context.Context, URL string) (string, error) {
+func (s *Service) Post(ctx context.Context, URL string, opts model.ShortOpts) (string, error) {
 	if len(URL) == 0 {
 		return "", config.ErrEmptyReqBody
 	}
@@ -40,32 +101,31 @@ func (s *Service) Post(ctx context.Context, URL string) (string, error) {
 	}
 
 	userID := ctx.Value(config.ContextKeyUserID).(string)
-	short, isCreated := s.findOrCreateShort(URL)
-	if isCreated {
-		if short == "" {
-			return "", config.ErrNoFreeIDs
-		}
-
-		newURL := &model.ShortURL{
-			URL:     URL,
-			Short:   short,
-			UserID:  userID,
-			Deleted: false,
-		}
-		if err := s.ds.Save(ctx, *newURL); err != nil {
-			return "", err
+	newURL, err := s.findOrCreateShort(ctx, URL, userID, opts)
+	short := newURL.Short
+	switch {
+	case err == nil:
+		err = s.ds.Save(ctx, newURL)
+		if err != nil {
+			s.urls.remove(short)
+			if !errors.Is(err, config.ErrDuplicateURL) {
+				return "", err
+			}
+			// url was saved to db by another instance
+			found, ok, ferr := s.urls.findShort(ctx, URL)
+			if ferr != nil || !ok {
+				return "", err
+			}
+			short = found
+			break
 		}
-		s.urls[newURL.Short] = newURL
-	}
-
-	if s.c.RetShrtWHost {
-		short = s.c.HostName + short
+		s.urls.commit(short)
+	case errors.Is(err, config.ErrDuplicateURL):
+	default:
+		return "", err
 	}
 
-	if !isCreated {
-		return short, config.ErrDuplicateURL
-	}
-	return short, nil
+	return s.hostName() + short, err
 }
 
 func (s *Service) PostBatch(ctx context.Context, URLs []map[string]string) ([]map[string]string, error) {
@@ -74,165 +134,252 @@ func (s *Service) PostBatch(ctx context.Context, URLs []map[string]string) ([]ma
 	}
 
 	userID := ctx.Value(config.ContextKeyUserID).(string)
-	hostName := ""
-	if s.c.RetShrtWHost {
-		hostName = s.c.HostName
-	}
+	hostName := s.hostName()
 	createdURLs := make([]*model.ShortURL, 0) // store slice for new records
 	res := make([]map[string]string, 0)       // map with result for browse
 	for _, URL := range URLs {
-		short, isCreated := s.findOrCreateShort(URL["original_url"])
-		if isCreated {
-			if short == "" {
-				return nil, config.ErrNoFreeIDs
-			}
-
-			newURL := &model.ShortURL{
-				URL:     URL["original_url"],
-				Short:   short,
-				UserID:  userID,
-				Deleted: false,
-			}
-			createdURLs = append(createdURLs, newURL)
+		opts := model.ShortOpts{
+			Alias:     URL[config.AliasTag],
+			TTL:       URL[config.TTLTag],
+			ExpiresAt: URL[config.ExpiresTag],
+		}
+		newURL, err := s.findOrCreateShort(ctx, URL["original_url"], userID, opts)
+		switch {
+		case err == nil:
+			createdURLs = append(createdURLs, &newURL)
+		case errors.Is(err, config.ErrDuplicateURL):
+		default:
+			s.removeAll(createdURLs)
+			return nil, err
 		}
 		rec := make(map[string]string)
 		rec["correlation_id"] = URL["correlation_id"]
-		rec["short_url"] = hostName + short
+		rec["short_url"] = hostName + newURL.Short
 		res = append(res, rec)
 	}
 	err := s.ds.SaveBatch(ctx, createdURLs)
 	if err != nil {
+		s.removeAll(createdURLs)
 		return nil, err
 	}
-	// merge created records map with  main map
-	for _, createRec := range createdURLs {
-		s.urls[createRec.Short] = createRec
+	for _, rec := range createdURLs {
+		s.urls.commit(rec.Short)
 	}
 	return res, nil
 }
 
+// Drops reservations of records from unsuccessful batch
+func (s *Service) removeAll(URLs []*model.ShortURL) {
+	for _, rec := range URLs {
+		s.urls.remove(rec.Short)
+	}
+}
+
 // Get stored URL for giver short url
-func (s *Service) Get(ID string) (string, error) {
-	recURL, ok := s.urls[ID]
+func (s *Service) Get(ctx context.Context, ID string) (string, error) {
+	recURL, ok, err := s.urls.get(ctx, ID)
+	if err != nil {
+		return "", err
+	}
 	if !ok {
 		return "", config.ErrNoSuchRecord
 	}
+	if isExpired(&recURL, time.Now()) {
+		return "", config.ErrURLExpired
+	}
 	if recURL.Deleted {
 		return "", config.ErrURLDeleted
 	}
 	return recURL.URL, nil
 }
 
-// Generate new short url or return saved for given url,
-// bool mean true if Short Url is created, or false if it found.
-func (s *Service) findOrCreateShort(url string) (string, bool) {
-	for _, rec := range s.urls {
-		if strings.EqualFold(url, rec.URL) {
-			return rec.Short, false
+// Generate new short url (or take alias from opts) and reserve it in store
+// for given url. If url is already stored returns record with its short url
+// and ErrDuplicateURL.
+func (s *Service) findOrCreateShort(ctx context.Context, url, userID string, opts model.ShortOpts) (model.ShortURL, error) {
+	if s.isBlocked(url) {
+		return model.ShortURL{}, config.ErrURLBlocked
+	}
+	now := time.Now()
+	expires, err := parseExpiry(opts, now)
+	if err != nil {
+		return model.ShortURL{}, err
+	}
+	short, found, err := s.urls.findShort(ctx, url)
+	if err != nil {
+		return model.ShortURL{}, err
+	}
+	if found {
+		// expired link not reaped yet does not hold its url
+		rec, changed, err := s.markExpired(ctx, short, now)
+		if err != nil {
+			return model.ShortURL{}, err
+		}
+		if !changed {
+			if opts.Alias != "" && opts.Alias != short {
+				// old short id is not what caller asked for
+				return model.ShortURL{}, config.ErrURLHasShort
+			}
+			return model.ShortURL{Short: short}, config.ErrDuplicateURL
+		}
+		if err := s.ds.UpdateBatch(ctx, []*model.ShortURL{&rec}); err != nil {
+			return model.ShortURL{}, err
 		}
 	}
 
-	rndStr := GetRandStr(s.c.LenShortURL)
+	newURL := model.ShortURL{
+		URL:       url,
+		UserID:    userID,
+		Deleted:   false,
+		ExpiresAt: expires,
+	}
+	if opts.Alias != "" {
+		if err := validateAlias(opts.Alias); err != nil {
+			return model.ShortURL{}, err
+		}
+		newURL.Short = opts.Alias
+		short, err := s.urls.reserve(ctx, newURL)
+		if errors.Is(err, errShortBusy) {
+			return model.ShortURL{}, config.ErrAliasTaken
+		}
+		if err != nil {
+			return model.ShortURL{Short: short}, err
+		}
+		return newURL, nil
+	}
+
 	// check: if generated short string for url is already buzy,
-	// rerandomize it again. (or change to bigger value types.LenShortUrl)
+	// generate it again. After maxTry collisions in a row keyspace
+	// is considered crowded and length of short ids grows.
 	const maxTry = 10
-	ik := 0
-	for ik < maxTry {
-		if _, ok := s.urls[rndStr]; ok && (ik < maxTry) {
-			rndStr = GetRandStr(s.c.LenShortURL)
-			ik++
+	for attempt := 0; ; attempt++ {
+		length := int(s.lenKey.Load())
+		if attempt > 0 && attempt%maxTry == 0 {
+			length = s.growLenKey(length)
+		}
+		if length > config.MaxLenShortURL {
+			return model.ShortURL{}, config.ErrNoFreeIDs
+		}
+		newURL.Short = s.gen.Generate(url, attempt, length)
+		if len(newURL.Short) > config.MaxLenShortURL {
+			return model.ShortURL{}, config.ErrNoFreeIDs
+		}
+		if isReserved(newURL.Short) {
 			continue
 		}
-		break
-	}
-	if ik == maxTry {
-		return "", false
+		short, err := s.urls.reserve(ctx, newURL)
+		switch {
+		case err == nil:
+			return newURL, nil
+		case errors.Is(err, errShortBusy):
+		default:
+			// same url was stored by concurrent request, or store failed
+			return model.ShortURL{Short: short}, err
+		}
 	}
+}
 
-	return rndStr, true
+// Makes generated short ids one char longer, if some concurrent
+// request has not done it yet. Returns new length.
+func (s *Service) growLenKey(length int) int {
+	if s.lenKey.CompareAndSwap(int32(length), int32(length+1)) {
+		log.Printf("keyspace is crowded, length of short id grown to %d", length+1)
+	}
+	return int(s.lenKey.Load())
 }
 
 // Returns map of short|long urls stored by given user
-func (s *Service) GetURLByUser(userID string) []map[string]string {
+func (s *Service) GetURLByUser(ctx context.Context, userID string) ([]map[string]string, error) {
 	res := make([]map[string]string, 0)
-	hostName := ""
-	if s.c.RetShrtWHost {
-		hostName = s.c.HostName
+	hostName := s.hostName()
+	urls, err := s.urls.byUser(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
-	for _, url := range s.urls {
-		if url.UserID == userID {
-			rec := make(map[string]string)
-			rec["short_url"] = hostName + url.Short
-			rec["original_url"] = url.URL
-			res = append(res, rec)
+	for _, url := range urls {
+		rec := make(map[string]string)
+		rec["short_url"] = hostName + url.Short
+		rec["original_url"] = url.URL
+		if url.ExpiresAt != nil {
+			rec[config.ExpiresTag] = url.ExpiresAt.Format(time.RFC3339)
 		}
+		res = append(res, rec)
 	}
-	return res
+	return res, nil
 }
 
 func (s *Service) PingDB(ctx context.Context) error {
 	return s.ds.Ping(ctx)
 }
 
-func (s *Service) GetLen() int {
-	return len(s.urls)
+// Returns stored secret of name, candidate is stored if there is none
+func (s *Service) Secret(ctx context.Context, name, candidate string) (string, error) {
+	return s.ds.Secret(ctx, name, candidate)
 }
 
-func GetRandStr(lenStr int) string {
-
-	var availChars = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+func (s *Service) GetLen(ctx context.Context) (int, error) {
+	return s.urls.len(ctx)
+}
 
-	res := make([]byte, lenStr)
-	for ik := 0; ik < lenStr; ik++ {
-		res[ik] = availChars[rand.Intn(len(availChars))]
+// Returns counts of urls, their owners, deleted urls and saved clicks
+func (s *Service) GetInternalStats(ctx context.Context) (model.InternalStats, error) {
+	res, err := s.urls.stats(ctx)
+	if err != nil {
+		return res, err
 	}
+	res.Clicks, err = s.ds.ClickCount(ctx)
+	return res, err
+}
 
-	return string(res)
+// Returns cache metrics, empty if all records are in memory
+func (s *Service) Metrics() map[string]int64 {
+	return s.urls.metrics()
 }
 
-func isURLok(URL string) bool {
-	u, err := url.Parse(URL)
-	if err != nil || u.Scheme == "" || u.Host == "" {
-		return false
+func (s *Service) logMetrics(ctx context.Context) {
+	ticker := time.NewTicker(s.c.CacheMetrics)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Printf("cache metrics: %v", s.urls.metrics())
+		}
 	}
-	return true
 }
 
-func markDeleted(ctx context.Context, URL *model.ShortURL, mu *sync.RWMutex) error {
-	userID := ctx.Value(config.ContextKeyUserID).(string)
-	if userID == URL.UserID {
-		mu.Lock()
-		defer mu.Unlock()
-		if URL.Deleted {
-			return fmt.Errorf("link %s already deleted ", URL.Short)
+// Checks alias is short id of allowed chars and length and is not reserved
+func validateAlias(alias string) error {
+	if len(alias) < config.MinLenAlias || len(alias) > config.MaxLenShortURL {
+		return config.ErrAliasNotValid
+	}
+	for _, ch := range alias {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '-', ch == '_':
+		default:
+			return config.ErrAliasNotValid
 		}
-		URL.Deleted = true
-	} else {
-		return fmt.Errorf("can't delete %s. only owner can ", URL.Short)
+	}
+	if isReserved(alias) {
+		return config.ErrAliasReserved
 	}
 	return nil
 }
 
-func (s *Service) DeleteURLs(ctx context.Context, shorts []string) error {
-	shortURLs := make([]*model.ShortURL, 0)
-	for _, short := range shorts {
-		url, err := url.Parse(short)
-		if err != nil {
-			return err
+func isReserved(short string) bool {
+	for _, word := range config.ReservedAliases {
+		if strings.EqualFold(short, word) {
+			return true
 		}
-		str := strings.TrimPrefix(url.Path, "/")
-		shortURL := s.urls[str]
-		shortURLs = append(shortURLs, shortURL)
 	}
-	go func() {
-		proc := NewProcessor(ctx, markDeleted)
-		errs := proc.ProceedWith(shortURLs)
-		if len(errs) > 0 {
-			for _, errv := range errs {
-				log.Printf(" error deleting url: %s\n", errv.Error())
-			}
-		}
-		s.ds.UpdateBatch(ctx, shortURLs)
-	}()
-	return nil
+	return false
+}
+
+func isURLok(URL string) bool {
+	u, err := url.Parse(URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	return true
 }
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_InternalStats(t *testing.T) {
+	c := &config.Config{LenShortURL: 5}
+	ds := repository.New(c)
+	s := New(ds, c)
+	asUser := func(userID string) context.Context {
+		return context.WithValue(context.Background(), config.ContextKeyUserID, userID)
+	}
+
+	for ik, userID := range []string{"user1", "user1", "user2"} {
+		_, err := s.Post(asUser(userID), "http://stats.ru/"+string(rune('a'+ik)), model.ShortOpts{})
+		require.NoError(t, err)
+	}
+	found, err := s.AdminSearch(context.Background(), model.URLFilter{UserID: "user2"})
+	require.NoError(t, err)
+	_, err = s.AdminDelete(asUser("root"), found[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, ds.SaveClicks(context.Background(), []model.Click{
+		{Short: found[0].ID, Time: time.Now()},
+		{Short: found[0].ID, Time: time.Now()},
+	}))
+
+	stats, err := s.GetInternalStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, model.InternalStats{URLs: 3, Users: 2, Deleted: 1, Clicks: 2}, stats)
+}
+
+func TestService_Close(t *testing.T) {
+	c := &config.Config{
+		LenShortURL:   5,
+		FileStorage:   filepath.Join(t.TempDir(), "storage"),
+		ClickBuffer:   1000,
+		ClickFlush:    time.Hour,
+		DeleteWorkers: 1,
+		DeleteRetries: 1,
+	}
+	ds := repository.New(c)
+	s := New(ds, c)
+	ctx := context.WithValue(context.Background(), config.ContextKeyUserID, "user1")
+
+	shorts := make([]string, 0)
+	for ik := 0; ik < 10; ik++ {
+		short, err := s.Post(ctx, fmt.Sprintf("http://close%d.ru", ik), model.ShortOpts{})
+		require.NoError(t, err)
+		shorts = append(shorts, short)
+		s.RecordClick(model.Click{Short: short, Time: time.Now()})
+	}
+	jobID, err := s.DeleteURLs(ctx, shorts)
+	require.NoError(t, err)
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Close(closeCtx))
+	require.NoError(t, ds.Close())
+
+	// buffered clicks and accepted deletion are saved
+	ds = repository.New(c)
+	clicks, err := ds.ClickCount(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 10, clicks)
+	job, err := ds.GetDeleteJob(context.Background(), jobID)
+	require.NoError(t, err)
+	assert.True(t, job.Done)
+	assert.Len(t, job.Results, 10)
+}
+
+func TestService_Reload(t *testing.T) {
+	c := &config.Config{LenShortURL: 5, HostName: "http://localhost:8080/", RetShrtWHost: true}
+	s := New(repository.New(c), c)
+	ctx := context.WithValue(context.Background(), config.ContextKeyUserID, "user1")
+
+	short, err := s.Post(ctx, "http://reload.ru", model.ShortOpts{})
+	require.NoError(t, err)
+	assert.Contains(t, short, c.HostName)
+	admin, err := s.IsAdmin(ctx, "user1")
+	require.NoError(t, err)
+	assert.False(t, admin)
+
+	s.Reload(config.Runtime{RetShrtWHost: false, Admins: "user1"})
+	again, err := s.Post(ctx, "http://reload.ru", model.ShortOpts{})
+	assert.ErrorIs(t, err, config.ErrDuplicateURL)
+	assert.Equal(t, short, c.HostName+again)
+	admin, err = s.IsAdmin(ctx, "user1")
+	require.NoError(t, err)
+	assert.True(t, admin)
+
+	s.Reload(config.Runtime{BlockedHosts: "spam.ru, Phish.example.com"})
+	for _, URL := range []string{"http://spam.ru/a", "https://www.spam.ru", "http://PHISH.example.com:8080/x"} {
+		_, err = s.Post(ctx, URL, model.ShortOpts{})
+		assert.ErrorIs(t, err, config.ErrURLBlocked, URL)
+	}
+	_, err = s.PostBatch(ctx, []map[string]string{{"correlation_id": "1", "original_url": "http://spam.ru/b"}})
+	assert.ErrorIs(t, err, config.ErrURLBlocked)
+	for _, URL := range []string{"http://notspam.ru", "http://example.com"} {
+		_, err = s.Post(ctx, URL, model.ShortOpts{})
+		assert.NoError(t, err, URL)
+	}
+}
+
+func TestService_AliasOfShortenedURL(t *testing.T) {
+	c := &config.Config{LenShortURL: 5}
+	s := New(repository.New(c), c)
+	ctx := context.WithValue(context.Background(), config.ContextKeyUserID, "user1")
+
+	short, err := s.Post(ctx, "http://alias.ru", model.ShortOpts{})
+	require.NoError(t, err)
+	_, err = s.Post(ctx, "http://alias.ru", model.ShortOpts{Alias: "sale"})
+	assert.ErrorIs(t, err, config.ErrURLHasShort)
+	again, err := s.Post(ctx, "http://alias.ru", model.ShortOpts{})
+	assert.ErrorIs(t, err, config.ErrDuplicateURL)
+	assert.Equal(t, short, again)
+
+	_, err = s.Post(ctx, "http://alias2.ru", model.ShortOpts{Alias: "sale2"})
+	require.NoError(t, err)
+	again, err = s.Post(ctx, "http://alias2.ru", model.ShortOpts{Alias: "sale2"})
+	assert.ErrorIs(t, err, config.ErrDuplicateURL, "the same alias is not an error")
+	assert.Equal(t, "sale2", again)
+
+	_, err = s.PostBatch(ctx, []map[string]string{
+		{"correlation_id": "1", "original_url": "http://alias3.ru"},
+		{"correlation_id": "2", "original_url": "http://alias.ru", config.AliasTag: "sale3"},
+	})
+	assert.ErrorIs(t, err, config.ErrURLHasShort)
+	_, err = s.Post(ctx, "http://alias3.ru", model.ShortOpts{})
+	assert.NoError(t, err, "batch is rolled back")
+}
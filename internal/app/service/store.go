@@ -0,0 +1,316 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/config"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/model"
+	"github.com/e-pas/yandex-praktikum-shortener/internal/app/repository"
+)
+
+// urlStore is where service looks for short url records.
+// fullStore keeps every record in memory index loaded at start,
+// lazyStore reads records from postgres on demand through LRU cache.
+//
+// New record is first reserved, then service saves it to repository
+// and either commits (saved) or removes (not saved) the reservation.
+// Records changed by update are saved by service too.
+type urlStore interface {
+	get(ctx context.Context, short string) (model.ShortURL, bool, error)
+	findShort(ctx context.Context, URL string) (string, bool, error)
+	reserve(ctx context.Context, rec model.ShortURL) (string, error)
+	commit(short string)
+	remove(short string)
+	update(ctx context.Context, short string, fn func(rec *model.ShortURL) error) (model.ShortURL, error)
+	byUser(ctx context.Context, userID string) ([]model.ShortURL, error)
+	expired(ctx context.Context, now time.Time) ([]model.ShortURL, error)
+	search(ctx context.Context, filter model.URLFilter) ([]model.ShortURL, error)
+	stats(ctx context.Context) (model.InternalStats, error)
+	len(ctx context.Context) (int, error)
+	metrics() map[string]int64
+}
+
+type fullStore struct {
+	idx *urlIndex
+}
+
+func newFullStore(ctx context.Context, ds *repository.Repository) *fullStore {
+	fs := &fullStore{
+		idx: newURLIndex(),
+	}
+	data := make(map[string]*model.ShortURL, 0)
+	ds.Load(ctx, data)
+	fs.idx.load(data)
+	return fs
+}
+
+func (fs *fullStore) get(ctx context.Context, short string) (model.ShortURL, bool, error) {
+	rec, ok := fs.idx.get(short)
+	return rec, ok, nil
+}
+
+func (fs *fullStore) findShort(ctx context.Context, URL string) (string, bool, error) {
+	short, ok := fs.idx.findShort(URL)
+	return short, ok, nil
+}
+
+func (fs *fullStore) reserve(ctx context.Context, rec model.ShortURL) (string, error) {
+	return fs.idx.add(rec)
+}
+
+func (fs *fullStore) commit(short string) {}
+
+func (fs *fullStore) remove(short string) {
+	fs.idx.remove(short)
+}
+
+func (fs *fullStore) update(ctx context.Context, short string, fn func(rec *model.ShortURL) error) (model.ShortURL, error) {
+	return fs.idx.update(short, fn)
+}
+
+func (fs *fullStore) byUser(ctx context.Context, userID string) ([]model.ShortURL, error) {
+	return fs.idx.filter(func(rec *model.ShortURL) bool {
+		return rec.UserID == userID
+	}), nil
+}
+
+func (fs *fullStore) expired(ctx context.Context, now time.Time) ([]model.ShortURL, error) {
+	return fs.idx.filter(func(rec *model.ShortURL) bool {
+		return !rec.Deleted && isExpired(rec, now)
+	}), nil
+}
+
+// Records are ordered by short id, as in postgres search
+func (fs *fullStore) search(ctx context.Context, filter model.URLFilter) ([]model.ShortURL, error) {
+	query := strings.ToLower(filter.Query)
+	found := fs.idx.filter(func(rec *model.ShortURL) bool {
+		switch {
+		case query != "" && rec.Short != filter.Query && !strings.Contains(strings.ToLower(rec.URL), query):
+			return false
+		case filter.UserID != "" && rec.UserID != filter.UserID:
+			return false
+		case filter.Deleted != nil && rec.Deleted != *filter.Deleted:
+			return false
+		}
+		return true
+	})
+	sort.Slice(found, func(i, j int) bool { return found[i].Short < found[j].Short })
+	if filter.Offset >= len(found) {
+		return []model.ShortURL{}, nil
+	}
+	found = found[filter.Offset:]
+	if len(found) > filter.Limit {
+		found = found[:filter.Limit]
+	}
+	return found, nil
+}
+
+func (fs *fullStore) stats(ctx context.Context) (model.InternalStats, error) {
+	res := model.InternalStats{}
+	users := make(map[string]struct{})
+	fs.idx.filter(func(rec *model.ShortURL) bool {
+		res.URLs++
+		if rec.Deleted {
+			res.Deleted++
+		}
+		users[rec.UserID] = struct{}{}
+		return false
+	})
+	res.Users = len(users)
+	return res, nil
+}
+
+func (fs *fullStore) len(ctx context.Context) (int, error) {
+	return fs.idx.len(), nil
+}
+
+func (fs *fullStore) metrics() map[string]int64 {
+	return nil
+}
+
+// lazyStore caches records by short id and short ids by url. Misses
+// are cached too (negative caching) for negTTL, so requests for unknown
+// ids do not hit db every time. Reserved but not yet saved records live
+// in pending index, it keeps concurrent requests from taking the same
+// url or short id.
+type lazyStore struct {
+	ds      *repository.Repository
+	pending *urlIndex
+	shorts  *lru[string, cachedURL]
+	urls    *lru[string, cachedShort]
+	negTTL  time.Duration
+	locks   [shardCount]sync.Mutex // serialize updates of the same short id
+}
+
+type cachedURL struct {
+	rec     model.ShortURL
+	missing bool
+	until   time.Time // negative entry is valid until
+}
+
+type cachedShort struct {
+	short   string
+	missing bool
+	until   time.Time
+}
+
+func newLazyStore(ds *repository.Repository, c *config.Config) *lazyStore {
+	return &lazyStore{
+		ds:      ds,
+		pending: newURLIndex(),
+		shorts:  newLRU[string, cachedURL](c.CacheSize),
+		urls:    newLRU[string, cachedShort](c.CacheSize),
+		negTTL:  c.CacheNegTTL,
+	}
+}
+
+func (ls *lazyStore) get(ctx context.Context, short string) (model.ShortURL, bool, error) {
+	if rec, ok := ls.pending.get(short); ok {
+		return rec, true, nil
+	}
+	if cached, ok := ls.shorts.get(short); ok {
+		if !cached.missing {
+			return cached.rec, true, nil
+		}
+		if time.Now().Before(cached.until) {
+			return model.ShortURL{}, false, nil
+		}
+	}
+	rec, err := ls.ds.Get(ctx, short)
+	switch {
+	case errors.Is(err, config.ErrNoSuchRecord):
+		ls.shorts.put(short, cachedURL{missing: true, until: time.Now().Add(ls.negTTL)})
+		return model.ShortURL{}, false, nil
+	case err != nil:
+		return model.ShortURL{}, false, err
+	}
+	ls.shorts.put(short, cachedURL{rec: rec})
+	return rec, true, nil
+}
+
+func (ls *lazyStore) findShort(ctx context.Context, URL string) (string, bool, error) {
+	if short, ok := ls.pending.findShort(URL); ok {
+		return short, true, nil
+	}
+	key := urlKey(URL)
+	if cached, ok := ls.urls.get(key); ok {
+		if !cached.missing {
+			return cached.short, true, nil
+		}
+		if time.Now().Before(cached.until) {
+			return "", false, nil
+		}
+	}
+	short, err := ls.ds.FindByURL(ctx, URL)
+	switch {
+	case errors.Is(err, config.ErrNoSuchRecord):
+		ls.urls.put(key, cachedShort{missing: true, until: time.Now().Add(ls.negTTL)})
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	}
+	ls.urls.put(key, cachedShort{short: short})
+	return short, true, nil
+}
+
+func (ls *lazyStore) reserve(ctx context.Context, rec model.ShortURL) (string, error) {
+	short, found, err := ls.findShort(ctx, rec.URL)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return short, config.ErrDuplicateURL
+	}
+	_, found, err = ls.get(ctx, rec.Short)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return "", errShortBusy
+	}
+	return ls.pending.add(rec)
+}
+
+// Saved record goes from pending index to cache
+func (ls *lazyStore) commit(short string) {
+	rec, ok := ls.pending.get(short)
+	if !ok {
+		return
+	}
+	ls.shorts.put(short, cachedURL{rec: rec})
+	ls.urls.put(urlKey(rec.URL), cachedShort{short: short})
+	ls.pending.remove(short)
+}
+
+// Drops reservation and cached misses for its url and short id,
+// as record may be saved by another instance
+func (ls *lazyStore) remove(short string) {
+	if rec, ok := ls.pending.get(short); ok {
+		ls.urls.remove(urlKey(rec.URL))
+	}
+	ls.shorts.remove(short)
+	ls.pending.remove(short)
+}
+
+func (ls *lazyStore) update(ctx context.Context, short string, fn func(rec *model.ShortURL) error) (model.ShortURL, error) {
+	lock := &ls.locks[shardNum(short)]
+	lock.Lock()
+	defer lock.Unlock()
+
+	old, found, err := ls.get(ctx, short)
+	if err != nil {
+		return model.ShortURL{}, err
+	}
+	if !found {
+		return model.ShortURL{}, config.ErrNoSuchRecord
+	}
+	changed := old
+	if err := fn(&changed); err != nil {
+		return old, err
+	}
+	ls.shorts.put(short, cachedURL{rec: changed})
+	if !old.Deleted {
+		ls.urls.remove(urlKey(old.URL))
+	}
+	if !changed.Deleted {
+		ls.urls.put(urlKey(changed.URL), cachedShort{short: short})
+	}
+	return changed, nil
+}
+
+func (ls *lazyStore) byUser(ctx context.Context, userID string) ([]model.ShortURL, error) {
+	return ls.ds.ListByUser(ctx, userID)
+}
+
+func (ls *lazyStore) expired(ctx context.Context, now time.Time) ([]model.ShortURL, error) {
+	return ls.ds.ListExpired(ctx, now)
+}
+
+func (ls *lazyStore) search(ctx context.Context, filter model.URLFilter) ([]model.ShortURL, error) {
+	return ls.ds.Search(ctx, filter)
+}
+
+func (ls *lazyStore) stats(ctx context.Context) (model.InternalStats, error) {
+	return ls.ds.URLStats(ctx)
+}
+
+func (ls *lazyStore) len(ctx context.Context) (int, error) {
+	return ls.ds.Count(ctx)
+}
+
+func (ls *lazyStore) metrics() map[string]int64 {
+	res := make(map[string]int64)
+	for name, stats := range map[string]cacheStats{"short": ls.shorts.getStats(), "url": ls.urls.getStats()} {
+		res[fmt.Sprintf("cache_%s_len", name)] = int64(stats.Len)
+		res[fmt.Sprintf("cache_%s_hits", name)] = stats.Hits
+		res[fmt.Sprintf("cache_%s_misses", name)] = stats.Misses
+		res[fmt.Sprintf("cache_%s_evictions", name)] = stats.Evictions
+	}
+	return res
+}
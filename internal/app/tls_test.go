@@ -0,0 +1,46 @@
+package app
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfSignedCert(t *testing.T) {
+	hosts := certHosts("http://short.example.com:8080/")
+	assert.Contains(t, hosts, "short.example.com")
+
+	cert, err := selfSignedCert(hosts)
+	require.Nil(t, err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	require.Nil(t, err)
+	assert.Nil(t, parsed.VerifyHostname("short.example.com"))
+	assert.Nil(t, parsed.VerifyHostname("localhost"))
+	assert.Nil(t, parsed.VerifyHostname("127.0.0.1"))
+	assert.NotNil(t, parsed.VerifyHostname("other.example.com"))
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		name   string
+		listen string
+		target string
+		want   string
+	}{
+		{"same port", ":8443", "http://localhost:8080/abc?x=1", "https://localhost:8443/abc?x=1"},
+		{"default port", ":443", "http://short.example.com/abc", "https://short.example.com/abc"},
+		{"ipv6", ":443", "http://[::1]:8080/abc", "https://[::1]/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			redirectHTTPS(tt.listen).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+			assert.Equal(t, tt.want, rec.Header().Get("Location"))
+		})
+	}
+}